@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Проверка admin токена из query параметра token или заголовка Authorization
+func isAdmin(r *http.Request) bool {
+	if adminToken == "" {
+		return false
+	}
+	token := tokenFromRequest(r)
+	return subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+// Рассылка события всем admin подписчикам; медленные подписчики теряют события
+func (h *Hub) emitAdminEvent(event Message) {
+	h.adminSubscribersMu.Lock()
+	defer h.adminSubscribersMu.Unlock()
+
+	for subscriber := range h.adminSubscribers {
+		subscriber.send(event)
+	}
+}
+
+// POST /admin/rooms/{id}/close: закрытие комнаты с отключением всех участников
+func (h *Hub) handleCloseRoom(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	roomID := r.PathValue("id")
+	closed, ok := h.closeRoom(roomID)
+	if !ok {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"roomId":  roomID,
+		"clients": closed,
+	})
+}
+
+// WebSocket поток событий: room-created, room-destroyed, user-joined, user-left
+func (h *Hub) handleAdminEvents(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Warn("Ошибка upgrade admin соединения", "error", err)
+		return
+	}
+
+	subscriber := newClient(conn)
+
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	h.adminSubscribersMu.Lock()
+	h.adminSubscribers[subscriber] = true
+	h.adminSubscribersMu.Unlock()
+
+	go subscriber.writePump()
+	logger.Info("Подключен подписчик admin событий", "client_id", subscriber.ID)
+
+	// Входящие сообщения не обрабатываются; чтение нужно для ping/pong и обнаружения закрытия
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	h.adminSubscribersMu.Lock()
+	delete(h.adminSubscribers, subscriber)
+	h.adminSubscribersMu.Unlock()
+
+	subscriber.closeSend()
+	subscriber.cancel()
+	conn.Close()
+	logger.Info("Отключен подписчик admin событий", "client_id", subscriber.ID)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// ADMIN_TOKEN на время теста
+func withAdminToken(t *testing.T, token string) {
+	saved := adminToken
+	adminToken = token
+	t.Cleanup(func() { adminToken = saved })
+}
+
+func TestRoomEndpointsRequireAdmin(t *testing.T) {
+	withAdminToken(t, "secret")
+	h := newHub()
+	joinFake(t, h, "private", "alice")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /rooms", h.handleRooms)
+	mux.HandleFunc("GET /rooms/{id}", h.handleRoom)
+	mux.HandleFunc("GET /stats", h.handleStats)
+
+	tests := []struct {
+		path  string
+		token string
+		want  int
+	}{
+		{"/rooms", "", http.StatusForbidden},
+		{"/rooms", "wrong", http.StatusForbidden},
+		{"/rooms", "secret", http.StatusOK},
+		{"/rooms/private", "", http.StatusForbidden},
+		{"/rooms/private", "secret", http.StatusOK},
+		{"/stats", "", http.StatusForbidden},
+		{"/stats", "secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("GET %s with token %q: status %d, want %d", tt.path, tt.token, rec.Code, tt.want)
+		}
+	}
+}
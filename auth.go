@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Данные пользователя, извлеченные из JWT
+type AuthClaims struct {
+	Username string `json:"username,omitempty"`
+	Name     string `json:"name,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Извлечение токена из query параметра token или заголовка Authorization
+func tokenFromRequest(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+
+	header := r.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// Проверка JWT, подписанного HMAC секретом JWT_SECRET.
+// Возвращает ID пользователя (sub) и имя пользователя из claims.
+func authenticate(r *http.Request) (userID, username string, err error) {
+	token := tokenFromRequest(r)
+	if token == "" {
+		return "", "", errors.New("токен не передан")
+	}
+
+	claims := &AuthClaims{}
+	_, err = jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		return []byte(jwtSecret), nil
+	}, jwt.WithValidMethods([]string{"HS256", "HS384", "HS512"}))
+	if err != nil {
+		return "", "", err
+	}
+
+	if claims.Subject == "" {
+		return "", "", errors.New("в токене нет sub")
+	}
+
+	username = claims.Username
+	if username == "" {
+		username = claims.Name
+	}
+	return claims.Subject, username, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import "errors"
+
+// Бинарные signaling фреймы для компактных клиентов (например, ICE в protobuf).
+// Формат входящего фрейма: 1 байт длины ID получателя, ID получателя, payload.
+// Получатель видит тот же формат, но с ID отправителя вместо ID получателя.
+// Управляющие сообщения сервера всегда остаются текстовыми JSON.
+
+// Разбор заголовка бинарного фрейма: ID адресата и payload
+func parseBinaryFrame(frame []byte) (string, []byte, error) {
+	if len(frame) < 1 {
+		return "", nil, errors.New("empty binary frame")
+	}
+	n := int(frame[0])
+	if n == 0 || len(frame) < 1+n {
+		return "", nil, errors.New("binary frame must start with the peer id length and id")
+	}
+	return string(frame[1 : 1+n]), frame[1+n:], nil
+}
+
+// Сборка бинарного фрейма с ID адресата (или отправителя) в заголовке
+func buildBinaryFrame(id string, payload []byte) []byte {
+	frame := make([]byte, 0, 1+len(id)+len(payload))
+	frame = append(frame, byte(len(id)))
+	frame = append(frame, id...)
+	return append(frame, payload...)
+}
+
+// Пересылка бинарного фрейма участнику комнаты
+func (h *Hub) handleBinary(client *Client, frame []byte) {
+	if client.RoomID == "" {
+		client.sendError("not-in-room", "join a room before sending binary messages")
+		return
+	}
+
+	to, payload, err := parseBinaryFrame(frame)
+	if err != nil {
+		logger.Warn("Некорректный бинарный фрейм", "client_id", client.ID, "room_id", client.RoomID, "error", err)
+		client.sendError("invalid-payload", err.Error())
+		return
+	}
+
+	// ID отправителя длиннее 255 байт не помещается в заголовок
+	if len(client.ID) > 255 {
+		client.sendError("invalid-payload", "sender id is too long for binary frames")
+		return
+	}
+
+	msg := Message{
+		Type:   "binary",
+		From:   client.ID,
+		To:     to,
+		Binary: buildBinaryFrame(client.ID, payload),
+	}
+	var relayRoom *Room
+	var target *Client
+	remote := false
+	h.inClientRoom(client, msg.Type, func(room *Room) {
+		relayRoom = room
+		room.touch()
+		// С Redis получателя, которого нет на этом инстансе, ищут остальные инстансы
+		if remote = h.cluster != nil && to != client.ID && room.Clients[to] == nil; remote {
+			return
+		}
+		target = signalingTarget(room, client, msg)
+	})
+	if target != nil {
+		relaySignaling(relayRoom, target, msg)
+	}
+	if remote {
+		h.publishRemote(relayRoom, msg)
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Структура для клиента
+type Client struct {
+	ID         string
+	AssignedID string // ID, выданный сервером при подключении
+	// Идентичность из JWT; если задана, имеет приоритет над from/username из join
+	AuthID       string
+	AuthUsername string
+	Conn         Conn   // nil для клиентов long polling
+	IP           string // Адрес клиента для ограничений по IP (с учетом X-Forwarded-For при TRUST_PROXY)
+	UserAgent    string // User-Agent запроса подключения, для логов
+	URLRoomID    string // Комната из URL подключения (/ws/{roomId} или ?roomId=)
+	Tenant       string // Арендатор, в пространстве которого ищутся комнаты
+	RoomID       string
+	Username     string
+	Send         chan Message
+	sendLow      chan Message // Сообщения типов из LOW_PRIORITY_TYPES; отправляются, когда Send пуст
+	mu           sync.Mutex
+	// Закрывается при закрытии клиента. Send не закрывается никогда: отправка с ожиданием
+	// выбирает между Send и done, и закрытие клиента не ждет ее завершения
+	done         chan struct{}
+	closed       bool
+	closeCode    int             // Код close фрейма, который writePump отправит после разбора очереди
+	closeReason  string          // Причина в close фрейме
+	removed      atomic.Bool     // Клиент отключен; после этого ему ничего не отправляется
+	dropped      int64           // Количество сообщений, отброшенных из-за переполнения Send
+	messagesSent int64           // Количество сообщений, полученных от клиента
+	resumeToken  string          // Токен переподключения из последнего joined
+	msgBucket    tokenBucket     // Лимит входящих сообщений (только из read loop)
+	rateLimited  bool            // Клиент уже уведомлен о превышении лимита сообщений
+	badJSON      int             // Невалидные JSON сообщения подряд (только из read loop)
+	media        *MediaState     // Последнее известное состояние микрофона и камеры
+	traffic      trafficCounters // Байты, полученные и отправленные по соединению
+	ConnectedAt  time.Time
+	// Заголовки из RELAY_HEADERS, видимые участникам; не меняются после подключения
+	Headers map[string]string
+	// Контекст соединения; отменяется при отключении и останавливает связанные горутины
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Создание клиента для нового соединения с выданным сервером ID
+func newClient(conn Conn) *Client {
+	id := newClientID()
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Client{
+		ID:          id,
+		AssignedID:  id,
+		Conn:        conn,
+		Send:        make(chan Message, sendBufferSize),
+		sendLow:     make(chan Message, lowPriorityBufferSize),
+		done:        make(chan struct{}),
+		ConnectedAt: time.Now(),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+// Состояние микрофона и камеры участника
+type MediaState struct {
+	Audio bool `json:"audio"`
+	Video bool `json:"video"`
+}
+
+// Неблокирующая отправка сообщения клиенту.
+// Если клиент закрыт, сообщение отбрасывается; при переполнении буфера действует
+// SEND_OVERFLOW_POLICY, чтобы медленный клиент не блокировал отправителя (и всю комнату).
+func (c *Client) send(msg Message) bool {
+	return c.enqueue(msg, c.ID, c.RoomID)
+}
+
+// send с ID клиента и комнаты для логов от вызывающего. Вне горутины комнаты и read loop
+// клиента его ID и RoomID читать нельзя: их меняет read loop при join и leave
+func (c *Client) enqueue(msg Message, clientID, roomID string) bool {
+	if c.removed.Load() {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+
+	queue := c.queueFor(msg)
+	select {
+	case queue <- msg:
+		return true
+	default:
+		return c.overflow(queue, msg, clientID, roomID)
+	}
+}
+
+// Буфер для сообщения по его приоритету
+func (c *Client) queueFor(msg Message) chan Message {
+	if slices.Contains(lowPriorityTypes, msg.Type) {
+		return c.sendLow
+	}
+	return c.Send
+}
+
+// Обработка переполнения буфера queue согласно SEND_OVERFLOW_POLICY; вызывается под c.mu.
+// Из-за сообщений низкого приоритета клиент не отключается: они просто отбрасываются.
+func (c *Client) overflow(queue chan Message, msg Message, clientID, roomID string) bool {
+	policy := sendOverflowPolicy
+	if policy == "disconnect" && queue == c.sendLow {
+		policy = "drop-newest"
+	}
+
+	switch policy {
+	case "drop-oldest":
+		select {
+		case old := <-queue:
+			c.dropped++
+			droppedSendsTotal.Inc()
+			deadLetter(deadLetterBufferFull, old, clientID, roomID, "policy", policy, "dropped_total", c.dropped)
+		default:
+		}
+		select {
+		case queue <- msg:
+			return true
+		default:
+		}
+	case "disconnect":
+		c.dropped++
+		droppedSendsTotal.Inc()
+		// Клиент уже закрывается: остальные сообщения отбрасываются молча
+		if c.closeCode != 0 {
+			return false
+		}
+		deadLetter(deadLetterBufferFull, msg, clientID, roomID, "policy", policy, "dropped_total", c.dropped)
+		c.closeCode = websocket.ClosePolicyViolation
+		c.closeReason = "send buffer overflow"
+		c.closeLocked()
+		return false
+	}
+
+	c.dropped++
+	droppedSendsTotal.Inc()
+	deadLetter(deadLetterBufferFull, msg, clientID, roomID, "policy", policy, "dropped_total", c.dropped)
+	return false
+}
+
+// Отправка с ожиданием места в буфере до timeout; при timeout <= 0 работает как send.
+// timedOut — буфер не освободился за timeout; дальше действует SEND_OVERFLOW_POLICY.
+// Ожидание прерывается закрытием клиента. Вызывать можно только вне горутины комнаты
+// и без удержания мьютексов; clientID и roomID — как в enqueue.
+func (c *Client) sendTimeout(msg Message, timeout time.Duration, clientID, roomID string) (sent, timedOut bool) {
+	if timeout <= 0 {
+		return c.enqueue(msg, clientID, roomID), false
+	}
+	if c.removed.Load() {
+		return false, false
+	}
+
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return false, false
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	queue := c.queueFor(msg)
+	select {
+	case queue <- msg:
+		return true, false
+	case <-c.done:
+		return false, false
+	case <-timer.C:
+		logger.Warn("Таймаут отправки клиенту", "client_id", clientID, "room_id", roomID, "msg_type", msg.Type, "timeout", timeout.String())
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.closed {
+			return false, true
+		}
+		// Место могло освободиться сразу после таймаута
+		select {
+		case queue <- msg:
+			return true, true
+		default:
+			return c.overflow(queue, msg, clientID, roomID), true
+		}
+	}
+}
+
+// Отправка клиенту сообщения об ошибке
+func (c *Client) sendError(code, reason string) {
+	c.send(Message{
+		Type:   "error",
+		Code:   code,
+		Reason: reason,
+	})
+}
+
+// Копия текущего состояния медиа клиента (nil, если еще не сообщалось)
+func (c *Client) mediaState() *MediaState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.media == nil {
+		return nil
+	}
+	state := *c.media
+	return &state
+}
+
+// Количество отброшенных сообщений клиента
+func (c *Client) droppedCount() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dropped
+}
+
+// Закрытие отправки: дальше клиенту ничего не ставится в очередь, writePump разберет
+// уже поставленное и завершится. Не блокируется; повторные вызовы безопасны
+func (c *Client) closeSend() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeLocked()
+}
+
+// closeSend для вызова под уже взятым c.mu
+func (c *Client) closeLocked() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.done)
+}
+
+// Закрытие соединения по инициативе сервера: writePump доставит уже поставленные
+// в очередь сообщения и отправит close фрейм с кодом и причиной
+func (c *Client) closeWith(code int, reason string) {
+	c.mu.Lock()
+	if !c.closed {
+		c.closeCode = code
+		c.closeReason = reason
+	}
+	c.mu.Unlock()
+	c.closeSend()
+}
+
+// Отправка close фрейма; вызывается из writePump или при единичных ошибках в read loop
+func (c *Client) writeClose(code int, reason string) {
+	data := websocket.FormatCloseMessage(code, reason)
+	if err := c.Conn.WriteControl(websocket.CloseMessage, data, time.Now().Add(writeWait)); err != nil {
+		c.logWriteError("Ошибка отправки close фрейма клиенту", err)
+	}
+}
+
+// Максимальная длина сохраняемого User-Agent
+const maxUserAgentLength = 256
+
+// Атрибуты соединения для логов входа и выхода: адрес и User-Agent
+func (c *Client) connAttr() slog.Attr {
+	return slog.Group("conn", "ip", c.IP, "user_agent", c.UserAgent)
+}
+
+// Генерация случайного UUID v4 для идентификации соединения
+func newClientID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// Токен переподключения к комнате: случайная часть и ключ комнаты, по которому ищется ожидающий клиент.
+// Для клиента токен непрозрачен.
+func newResumeToken(roomKey string) string {
+	var b [24]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%x.%s", b[:], roomKey)
+}
+
+// Логирование ошибки записи. Ошибки из-за уже закрытого соединения ожидаемы
+// при отключении и пишутся только на debug уровне.
+func (c *Client) logWriteError(msg string, err error, attrs ...any) {
+	attrs = append([]any{"client_id", c.ID, "error", err}, attrs...)
+	if c.ctx.Err() != nil || errors.Is(err, net.ErrClosed) || errors.Is(err, websocket.ErrCloseSent) {
+		logger.Debug(msg, attrs...)
+		return
+	}
+	logger.Warn(msg, attrs...)
+}
+
+// Writer goroutine для клиента.
+// При ошибке записи (в том числе по таймауту) соединение закрывается,
+// read loop получает ошибку и удаляет клиента из комнаты.
+// Завершается и при отмене контекста клиента.
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.cancel()
+		c.Conn.Close()
+	}()
+
+	for {
+		// Send разбирается раньше sendLow: сообщения низкого приоритета ждут,
+		// пока в основном буфере ничего нет. После закрытия клиента сначала
+		// дописываются сообщения, оставшиеся в Send, затем отправляется close фрейм
+		var message Message
+		ok := true
+		select {
+		case message = <-c.Send:
+		default:
+			select {
+			case <-c.ctx.Done():
+				return
+			case message = <-c.Send:
+			case message = <-c.sendLow:
+			case <-c.done:
+				select {
+				case message = <-c.Send:
+				default:
+					ok = false
+				}
+			case <-ticker.C:
+				// Периодический ping для обнаружения мертвых соединений
+				c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+					c.logWriteError("Ошибка отправки ping клиенту", err)
+					return
+				}
+				continue
+			}
+		}
+
+		if !ok {
+			c.mu.Lock()
+			code, reason := c.closeCode, c.closeReason
+			c.mu.Unlock()
+			if code != 0 && c.ctx.Err() == nil {
+				c.writeClose(code, reason)
+			}
+			return
+		}
+		// Соединение уже разорвано: оставшиеся в буфере сообщения не пишем в мертвый сокет
+		if c.ctx.Err() != nil {
+			return
+		}
+
+		frameType, data := websocket.BinaryMessage, message.Binary
+		if message.Binary == nil {
+			var err error
+			frameType = websocket.TextMessage
+			data, err = json.Marshal(message)
+			if err != nil {
+				logger.Error("Ошибка сериализации сообщения", "client_id", c.ID, "msg_type", message.Type, "error", err)
+				continue
+			}
+		}
+
+		c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := c.Conn.WriteMessage(frameType, data); err != nil {
+			c.logWriteError("Ошибка отправки сообщения клиенту", err, "msg_type", message.Type)
+			return
+		}
+		c.countPayloadOut(len(data))
+	}
+}
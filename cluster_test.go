@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// Бинарный фрейм получателю на другом инстансе проходит через конверт Redis
+func TestClusterRelaysBinaryFrames(t *testing.T) {
+	// Redis не нужен: конверт забирается из очереди публикации и передается второму хабу
+	a, b := newHub(), newHub()
+	a.cluster = &clusterRelay{instance: "a", outbox: make(chan clusterEnvelope, clusterQueueSize)}
+	b.cluster = &clusterRelay{instance: "b", outbox: make(chan clusterEnvelope, clusterQueueSize)}
+
+	alice, _, _ := joinFake(t, a, "cluster", "alice")
+	bob, _, _ := joinFake(t, b, "cluster", "bob")
+
+	payload := []byte{0x00, 0x01, 0xfe, 0xff}
+	alice.writeBinary(t, buildBinaryFrame("bob", payload))
+
+	// До фрейма в очереди лежит user-joined alice
+	var env clusterEnvelope
+	for env.Binary == nil {
+		select {
+		case env = <-a.cluster.outbox:
+		case <-time.After(fakeConnWait):
+			t.Fatal("binary frame was not published for the remote peer")
+		}
+	}
+	data, err := json.Marshal(env)
+	if err != nil {
+		t.Fatalf("marshal envelope: %v", err)
+	}
+	var received clusterEnvelope
+	if err := json.Unmarshal(data, &received); err != nil {
+		t.Fatalf("unmarshal envelope: %v", err)
+	}
+	b.deliverRemote(received.RoomKey, received.message())
+
+	want := buildBinaryFrame("alice", payload)
+	select {
+	case got := <-bob.out:
+		if !bytes.Equal(got, want) {
+			t.Fatalf("bob got frame %v, want %v", got, want)
+		}
+	case <-time.After(fakeConnWait):
+		t.Fatal("bob did not receive the binary frame")
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Настройки сервера, читаемые из переменных окружения
+var (
+	// Максимальный размер входящего сообщения в байтах (SDP и ICE укладываются с запасом)
+	maxMessageSize = envInt64("MAX_MESSAGE_SIZE", 32*1024)
+
+	// Максимальный размер data в offer/answer/ice-candidate в байтах, отдельно от лимита фрейма;
+	// 0 — ограничивает только MAX_MESSAGE_SIZE
+	maxSignalingPayload = int(envInt64("MAX_SIGNALING_PAYLOAD", 0))
+
+	// Проверять структуру payload offer/answer/ice-candidate перед пересылкой
+	strictValidation = envBool("STRICT_VALIDATION", false)
+
+	// Писать payload пересылаемых сообщений (SDP, ICE) в debug лог; содержит чувствительные данные.
+	// Payload длиннее LOG_PAYLOAD_LIMIT байт обрезается; 0 — без обрезки.
+	logPayloads     = envBool("LOG_PAYLOADS", false)
+	logPayloadLimit = int(envInt64("LOG_PAYLOAD_LIMIT", 1024))
+
+	// Типы рассылаемых комнате сообщений (chat, broadcast, ...), которые возвращаются и отправителю.
+	// Клиент может запросить эхо для отдельного сообщения флагом echo.
+	echoTypes = envList("ECHO_TYPES")
+
+	// Принимаемые типы сообщений через запятую; пустой список — все поддерживаемые сервером.
+	// В строгом режиме клиент, приславший другой тип, отключается.
+	allowedMessageTypes = envList("ALLOWED_MESSAGE_TYPES")
+	strictMessageTypes  = envBool("STRICT_MESSAGE_TYPES", false)
+
+	// Разрешенные Origin через запятую; пустой список или "*" разрешают все
+	allowedOrigins = envList("ALLOWED_ORIGINS")
+
+	// Разрешенные значения заголовка Host через запятую; пустой список — без проверки
+	allowedHosts = envList("ALLOWED_HOSTS")
+
+	// Поведение при join с уже занятым в комнате ID:
+	// "reject" — отклонить новый join, "replace" — отключить старого клиента
+	duplicateIDPolicy = envString("DUPLICATE_ID_POLICY", "reject")
+
+	// Изоляция комнат по арендаторам: "origin" — по хосту заголовка Origin,
+	// "query" — по параметру tenant в URL подключения; пусто — общее пространство комнат
+	tenantSource = envString("TENANT_SOURCE", "")
+
+	// Максимум участников в комнате; 0 — без ограничений
+	maxRoomClients = int(envInt64("MAX_ROOM_CLIENTS", 0))
+
+	// Максимум одновременно подключенных клиентов на сервере; 0 — без ограничений
+	maxClients = int(envInt64("MAX_CLIENTS", 0))
+
+	// Максимум одновременно существующих комнат; 0 — без ограничений
+	maxRooms = int(envInt64("MAX_ROOMS", 0))
+
+	// Максимум комнат, создаваемых с одного IP за окно ROOMS_PER_IP_WINDOW; 0 — без ограничений
+	maxRoomsPerIP    = int(envInt64("ROOMS_PER_IP", 0))
+	roomsPerIPWindow = envDuration("ROOMS_PER_IP_WINDOW", time.Hour)
+
+	// Максимальная длина имени пользователя в символах; 0 — без ограничений
+	maxUsernameLength = int(envInt64("MAX_USERNAME_LENGTH", 64))
+
+	// Использовать выданный сервером ID для join без from; если выключено, такой join отклоняется
+	assignClientIDs = envBool("ASSIGN_CLIENT_IDS", true)
+
+	// HMAC секрет для проверки JWT; пустой — аутентификация отключена
+	jwtSecret = os.Getenv("JWT_SECRET")
+
+	// ICE серверы для /ice-servers: STUN и TURN через запятую,
+	// общий секрет coturn и время жизни выдаваемых TURN учетных данных.
+	// STUN серверы также приходят клиенту в joined. Без STUN_URLS используется публичный STUN Google,
+	// пустое значение STUN_URLS= отключает STUN.
+	stunURLs   = envListDefault("STUN_URLS", []string{"stun:stun.l.google.com:19302"})
+	turnURLs   = envList("TURN_URLS")
+	turnSecret = os.Getenv("TURN_SECRET")
+	turnTTL    = envDuration("TURN_TTL", 24*time.Hour)
+
+	// Ограничения соединений с одного IP: новых в минуту и одновременных; 0 — без ограничений
+	connRatePerMinute = int(envInt64("CONN_RATE_PER_MINUTE", 0))
+	maxConnsPerIP     = int(envInt64("MAX_CONNS_PER_IP", 0))
+
+	// Ограничение входящих сообщений клиента: в секунду и допустимый всплеск; 0 — без ограничений.
+	// Сообщения сверх лимита отбрасываются, клиент получает rate-limited.
+	clientMessageRate  = float64(envInt64("CLIENT_MESSAGES_PER_SECOND", 0))
+	clientMessageBurst = float64(max(1, envInt64("CLIENT_MESSAGE_BURST", 50)))
+
+	// Доверять X-Forwarded-For при определении IP (только за доверенным прокси)
+	trustProxy = envBool("TRUST_PROXY", false)
+
+	// Время на первый успешный join после подключения; 0 — без ограничения
+	joinTimeout = envDuration("JOIN_TIMEOUT", 15*time.Second)
+
+	// Время ожидания переподключения клиента после разрыва соединения; 0 — удалять сразу
+	reconnectGrace = envDuration("RECONNECT_GRACE", 10*time.Second)
+
+	// Комната без подключенных клиентов дольше этого времени удаляется janitor; 0 — отключено
+	roomIdleTTL = envDuration("ROOM_IDLE_TTL", 5*time.Minute)
+
+	// Через сколько без signaling, чата и т.п. участники комнаты получают inactivity-timeout
+	// и сколько после этого ждать активности до закрытия комнаты; 0 — не закрывать
+	roomInactivityTimeout = envDuration("ROOM_INACTIVITY_TIMEOUT", 0)
+	roomInactivityGrace   = envDuration("ROOM_INACTIVITY_GRACE", time.Minute)
+
+	// Максимальная длительность встречи от создания комнаты и за сколько до конца предупреждать
+	// участников; 0 — без ограничения
+	roomMaxDuration     = envDuration("ROOM_MAX_DURATION", 0)
+	roomDurationWarning = envDuration("ROOM_DURATION_WARNING", 5*time.Minute)
+
+	// Таймауты HTTP сервера: на чтение заголовков запроса (защита от slowloris при handshake)
+	// и на простой keep-alive соединения. На WebSocket после upgrade не влияют.
+	readHeaderTimeout = envDuration("HTTP_READ_HEADER_TIMEOUT", 10*time.Second)
+	idleTimeout       = envDuration("HTTP_IDLE_TIMEOUT", 120*time.Second)
+
+	// Пауза между переключением /readyz в 503 и остановкой приема соединений при завершении,
+	// чтобы балансировщик успел перестать направлять на сервер новый трафик; 0 — без паузы
+	readinessDrainDelay = envDuration("READINESS_DRAIN_DELAY", 5*time.Second)
+
+	// Окно объединения уведомлений о входе: все join за окно приходят остальным одним users-joined.
+	// 0 — отдельный user-joined на каждый вход.
+	joinNotifyWindow = envDuration("JOIN_NOTIFY_WINDOW", 0)
+
+	// Интервал рассылки room-state всем участникам для сверки списка; 0 — только по запросу sync
+	roomStateInterval = envDuration("ROOM_STATE_INTERVAL", 0)
+
+	// Сертификат и ключ для прямой раздачи wss://; без них сервер работает по HTTP
+	tlsCertFile = os.Getenv("TLS_CERT_FILE")
+	tlsKeyFile  = os.Getenv("TLS_KEY_FILE")
+
+	// Размер буфера исходящих сообщений клиента. Больший буфер переживает
+	// всплески trickle ICE, но позже обнаруживает медленного потребителя
+	// и расходует больше памяти на соединение.
+	sendBufferSize = max(1, int(envInt64("SEND_BUFFER_SIZE", 256)))
+
+	// После стольких невалидных JSON сообщений подряд клиент отключается; 0 — не отключать
+	maxBadJSON = int(envInt64("MAX_BAD_JSON", 10))
+
+	// Типы сообщений низкого приоритета: у них отдельный буфер, который разбирается, только когда
+	// основной пуст, поэтому при нагрузке отбрасываются они, а не signaling.
+	// Signaling типы в этот список не попадают, даже если указаны.
+	lowPriorityTypes = withoutSignalingTypes(envListDefault("LOW_PRIORITY_TYPES", []string{"typing", "chat"}))
+
+	// Размер буфера сообщений низкого приоритета; меньше основного, чтобы устаревшие
+	// typing и chat не копились у медленного клиента
+	lowPriorityBufferSize = max(1, int(envInt64("LOW_PRIORITY_BUFFER_SIZE", 32)))
+
+	// Поведение при переполнении буфера исходящих сообщений клиента:
+	// "drop-newest" — отбросить новое сообщение, "drop-oldest" — вытеснить самое старое из очереди,
+	// "disconnect" — отключить клиента, чтобы он переподключился и восстановил состояние
+	sendOverflowPolicy = envString("SEND_OVERFLOW_POLICY", "drop-newest")
+
+	// Размеры буферов ввода-вывода WebSocket на соединение в байтах. Это не лимит
+	// размера сообщения: большие сообщения читаются/пишутся за несколько проходов.
+	// 1 КБ хватает для типичных ICE candidate, offer/answer укладываются в несколько буферов.
+	readBufferSize  = int(envInt64("READ_BUFFER_SIZE", 1024))
+	writeBufferSize = int(envInt64("WRITE_BUFFER_SIZE", 1024))
+
+	// Сжатие WebSocket сообщений (permessage-deflate); экономит трафик ценой CPU
+	enableCompression = envBool("ENABLE_COMPRESSION", true)
+
+	// Резервный транспорт long polling (/poll) для сетей, где WebSocket заблокирован
+	enableLongPoll = envBool("ENABLE_LONG_POLL", false)
+	// Сколько GET /poll/{clientId} ждет сообщений, прежде чем вернуть пустой список
+	pollWait = envDuration("POLL_WAIT", 25*time.Second)
+	// Сессия без запросов дольше этого времени считается отключившимся клиентом
+	pollSessionTimeout = envDuration("POLL_SESSION_TIMEOUT", 60*time.Second)
+
+	// Сколько пересылка signaling сообщения ждет места в буфере медленного получателя;
+	// 0 — сообщение сразу отбрасывается. С DISCONNECT_SLOW_PEERS получатель, не успевший
+	// за это время, отключается.
+	relaySendTimeout    = envDuration("RELAY_SEND_TIMEOUT", 0)
+	disconnectSlowPeers = envBool("DISCONNECT_SLOW_PEERS", false)
+
+	// Webhook о создании и удалении комнат: URL, значение заголовка Authorization,
+	// таймаут одного запроса и число повторов при ошибке; пустой URL отключает webhook
+	webhookURL        = os.Getenv("WEBHOOK_URL")
+	webhookAuthHeader = os.Getenv("WEBHOOK_AUTH_HEADER")
+	webhookTimeout    = envDuration("WEBHOOK_TIMEOUT", 5*time.Second)
+	webhookRetries    = int(envInt64("WEBHOOK_RETRIES", 3))
+
+	// Redis для пересылки сообщений между инстансами; пустой URL — один инстанс без Redis
+	redisURL           = os.Getenv("REDIS_URL")
+	redisChannelPrefix = envString("REDIS_CHANNEL_PREFIX", "instantmeet:room:")
+
+	// Заголовки запроса подключения, которые передаются участникам в списке участников и user-joined
+	// (например, проверенный прокси email); без списка заголовки не передаются
+	relayHeaders = envList("RELAY_HEADERS")
+
+	// Токен для admin эндпоинтов, а также /rooms, /rooms/{id} и /stats; пустой — они недоступны
+	adminToken = os.Getenv("ADMIN_TOKEN")
+)
+
+// Чтение строковой переменной окружения со значением по умолчанию
+func envString(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// Чтение целочисленной переменной окружения со значением по умолчанию
+func envInt64(name string, def int64) int64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		logger.Warn("Некорректное значение переменной окружения", "name", name, "value", value, "default", def)
+		return def
+	}
+	return n
+}
+
+// Чтение булевой переменной окружения со значением по умолчанию
+func envBool(name string, def bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		logger.Warn("Некорректное значение переменной окружения", "name", name, "value", value, "default", def)
+		return def
+	}
+	return b
+}
+
+// Чтение длительности (например "30s", "5m") со значением по умолчанию
+func envDuration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		logger.Warn("Некорректное значение переменной окружения", "name", name, "value", value, "default", def.String())
+		return def
+	}
+	return d
+}
+
+// Типы, которые всегда идут через основной буфер: их потеря ломает установку соединения
+var signalingTypes = []string{"offer", "answer", "ice-candidate", "request-offer", "binary"}
+
+// Список LOW_PRIORITY_TYPES без signaling типов; исключенные типы попадают в лог
+func withoutSignalingTypes(types []string) []string {
+	return slices.DeleteFunc(types, func(t string) bool {
+		if !slices.Contains(signalingTypes, t) {
+			return false
+		}
+		logger.Warn("Signaling тип не может быть низкого приоритета, пропущен", "name", "LOW_PRIORITY_TYPES", "type", t)
+		return true
+	})
+}
+
+// Чтение списка значений, разделенных запятыми; пустые элементы пропускаются
+func envList(name string) []string {
+	return envListDefault(name, nil)
+}
+
+// Чтение списка через запятую; def используется, только если переменная не задана вовсе
+func envListDefault(name string, def []string) []string {
+	raw, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+
+	var values []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Транспорт клиента: методы *websocket.Conn, которыми пользуется сервер.
+// Позволяет подставить вместо сетевого соединения реализацию в памяти.
+type Conn interface {
+	ReadMessage() (messageType int, data []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	SetReadLimit(limit int64)
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	SetPongHandler(h func(appData string) error)
+	EnableWriteCompression(enable bool)
+	Close() error
+}
+
+var _ Conn = (*websocket.Conn)(nil)
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestServeConnOverFakeConn(t *testing.T) {
+	h := newHub()
+	conn, done := serveFake(t, h)
+
+	conn.write(t, Message{Type: "join", RoomID: "conn-test", From: "alice"})
+	if joined := conn.expect(t, "joined"); joined.RoomID != "conn-test" || joined.HostID != "alice" {
+		t.Fatalf("joined = %+v, want room conn-test hosted by alice", joined)
+	}
+
+	conn.Close()
+	waitServed(t, done)
+}
+
+func TestServerCloseReachesFakeConn(t *testing.T) {
+	h := newHub()
+	conn, done := serveFake(t, h)
+
+	conn.write(t, Message{Type: "join", RoomID: "conn-close", From: "bob"})
+	conn.expect(t, "joined")
+
+	// Закрытие комнаты администратором: соединение закрывает сервер, а не клиент
+	h.closeRoom(roomKey("", "conn-close"))
+
+	conn.expect(t, "room-closed")
+	waitServed(t, done)
+	if code := conn.sentCloseCode(); code != websocket.CloseNormalClosure {
+		t.Fatalf("close code = %d, want %d", code, websocket.CloseNormalClosure)
+	}
+}
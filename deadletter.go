@@ -0,0 +1,24 @@
+package main
+
+// Причины недоставки сообщений в dead letter логе и метке reason
+const (
+	deadLetterPeerNotFound   = "peer-not-found"
+	deadLetterBufferFull     = "buffer-full"
+	deadLetterRateLimited    = "rate-limited"
+	deadLetterRelayQueueFull = "relay-queue-full"
+	deadLetterTransport      = "unsupported-transport"
+)
+
+// Учет недоставленного сообщения: структурированная запись в лог и signaling_dead_letters_total.
+// to — получатель, которому сообщение не доставлено (пусто, если он не определен).
+// Тип сообщения задан сервером или проверен по knownMessageTypes, поэтому кардинальность метки ограничена.
+func deadLetter(reason string, msg Message, to, roomID string, attrs ...any) {
+	countDeadLetter(reason, msg.Type)
+	attrs = append([]any{"reason", reason, "msg_type", msg.Type, "from", msg.From, "to", to, "room_id", roomID}, attrs...)
+	logger.Warn("Сообщение не доставлено", attrs...)
+}
+
+// Учет без записи в лог — для серии отброшенных сообщений, о которой лог уже есть
+func countDeadLetter(reason, msgType string) {
+	deadLettersTotal.WithLabelValues(msgType, reason).Inc()
+}
@@ -0,0 +1,63 @@
+package main
+
+import "time"
+
+// Ограничение длительности встречи (ROOM_MAX_DURATION): за ROOM_DURATION_WARNING до конца
+// участники получают time-limit-warning, по истечении — time-limit-reached, и комната закрывается.
+// Отсчет идет от Room.CreatedAt. Вызывается при создании комнаты под h.roomsMu;
+// таймеры останавливаются в Room.stop при удалении комнаты.
+func (h *Hub) watchDuration(room *Room) {
+	if roomMaxDuration <= 0 {
+		return
+	}
+
+	if warnAt := roomMaxDuration - roomDurationWarning; roomDurationWarning > 0 && warnAt > 0 {
+		room.durationTimers = append(room.durationTimers, time.AfterFunc(warnAt, func() {
+			expiresAt := room.expiresAt()
+			// Удаленная комната не отвечает на do
+			room.do(func() {
+				for _, c := range room.Clients {
+					c.send(Message{
+						Type:      "time-limit-warning",
+						RoomID:    room.ID,
+						ExpiresAt: expiresAt,
+					})
+				}
+			})
+		}))
+	}
+
+	room.durationTimers = append(room.durationTimers, time.AfterFunc(roomMaxDuration, func() { h.expireRoom(room) }))
+}
+
+// Закрытие комнаты по истечении ROOM_MAX_DURATION
+func (h *Hub) expireRoom(room *Room) {
+	h.roomsMu.Lock()
+	closed := -1
+	room.do(func() {
+		for _, c := range room.Clients {
+			c.send(Message{
+				Type:   "time-limit-reached",
+				RoomID: room.ID,
+			})
+		}
+		closed = h.evictRoom(room, "time-limit")
+	})
+	h.roomsMu.Unlock()
+	if closed < 0 {
+		return
+	}
+
+	logger.Info("Комната закрыта по ограничению длительности", "room_id", room.key, "clients", closed, "max_duration", roomMaxDuration.String())
+	h.emitAdminEvent(Message{Type: "room-destroyed", RoomID: room.key})
+	h.queueWebhook("room-destroyed", room.key, closed)
+}
+
+// Время принудительного закрытия комнаты; nil — длительность не ограничена
+func (r *Room) expiresAt() *time.Time {
+	if roomMaxDuration <= 0 {
+		return nil
+	}
+	t := r.CreatedAt.Add(roomMaxDuration)
+	return &t
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Сколько тест ждет очередного сообщения от сервера
+const fakeConnWait = 2 * time.Second
+
+// Соединение в памяти вместо *websocket.Conn: тест пишет кадры клиента в in
+// и читает кадры, записанные сервером, из out. Ping и дедлайны игнорируются.
+type fakeConn struct {
+	in  chan fakeFrame
+	out chan []byte
+
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	mu        sync.Mutex
+	closeCode int // Код close фрейма от сервера; 0 — не отправлялся
+}
+
+var _ Conn = (*fakeConn)(nil)
+
+// Входящий кадр: тип WebSocket сообщения и данные
+type fakeFrame struct {
+	messageType int
+	data        []byte
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{
+		in:     make(chan fakeFrame, 64),
+		out:    make(chan []byte, 256),
+		closed: make(chan struct{}),
+	}
+}
+
+func (f *fakeConn) ReadMessage() (int, []byte, error) {
+	select {
+	case frame := <-f.in:
+		return frame.messageType, frame.data, nil
+	case <-f.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (f *fakeConn) WriteMessage(messageType int, data []byte) error {
+	if messageType == websocket.PingMessage {
+		return nil
+	}
+	select {
+	case <-f.closed:
+		return net.ErrClosed
+	default:
+	}
+	select {
+	case f.out <- append([]byte(nil), data...):
+		return nil
+	case <-f.closed:
+		return net.ErrClosed
+	}
+}
+
+func (f *fakeConn) WriteControl(messageType int, data []byte, _ time.Time) error {
+	if messageType == websocket.CloseMessage && len(data) >= 2 {
+		f.mu.Lock()
+		f.closeCode = int(data[0])<<8 | int(data[1])
+		f.mu.Unlock()
+	}
+	return nil
+}
+
+func (f *fakeConn) SetReadLimit(int64)                 {}
+func (f *fakeConn) SetReadDeadline(time.Time) error    { return nil }
+func (f *fakeConn) SetWriteDeadline(time.Time) error   { return nil }
+func (f *fakeConn) SetPongHandler(func(string) error)  {}
+func (f *fakeConn) EnableWriteCompression(enable bool) {}
+
+func (f *fakeConn) Close() error {
+	f.closeOnce.Do(func() { close(f.closed) })
+	return nil
+}
+
+// Сообщение от имени клиента
+func (f *fakeConn) send(msg Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("marshal %s: %w", msg.Type, err)
+	}
+	select {
+	case f.in <- fakeFrame{websocket.TextMessage, data}:
+		return nil
+	case <-f.closed:
+		return fmt.Errorf("send %s: connection closed", msg.Type)
+	}
+}
+
+// Бинарный кадр от имени клиента
+func (f *fakeConn) writeBinary(t testing.TB, data []byte) {
+	t.Helper()
+	select {
+	case f.in <- fakeFrame{websocket.BinaryMessage, data}:
+	case <-f.closed:
+		t.Fatal("send binary frame: connection closed")
+	}
+}
+
+// Ожидание сообщения типа msgType; сообщения других типов пропускаются.
+// Пустой msgType — любое следующее сообщение
+func (f *fakeConn) recv(msgType string) (Message, error) {
+	timeout := time.After(fakeConnWait)
+	for {
+		select {
+		case data := <-f.out:
+			var msg Message
+			if err := json.Unmarshal(data, &msg); err != nil {
+				return Message{}, fmt.Errorf("unmarshal %q: %w", data, err)
+			}
+			if msgType == "" || msg.Type == msgType {
+				return msg, nil
+			}
+		case <-timeout:
+			return Message{}, fmt.Errorf("no %q message within %s", msgType, fakeConnWait)
+		}
+	}
+}
+
+// send с остановкой теста при ошибке
+func (f *fakeConn) write(t testing.TB, msg Message) {
+	t.Helper()
+	if err := f.send(msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// recv с остановкой теста при ошибке
+func (f *fakeConn) expect(t testing.TB, msgType string) Message {
+	t.Helper()
+	msg, err := f.recv(msgType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// Код close фрейма, отправленного сервером
+func (f *fakeConn) sentCloseCode() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closeCode
+}
+
+// Запуск serveConn для нового клиента поверх fakeConn; канал закрывается,
+// когда serveConn завершится. По окончании теста соединение закрывается
+// и serveConn дожидается, чтобы следующий тест не застал его горутины
+func serveFake(t testing.TB, h *Hub) (*fakeConn, <-chan struct{}) {
+	conn := newFakeConn()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.serveConn(newClient(conn))
+	}()
+	t.Cleanup(func() {
+		conn.Close()
+		waitServed(t, done)
+	})
+	return conn, done
+}
+
+// Ожидание завершения serveConn
+func waitServed(t testing.TB, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(fakeConnWait):
+		t.Fatalf("serveConn did not return within %s", fakeConnWait)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import "slices"
+
+// Очередь поднятых рук комнаты: ID участников в порядке поднятия (FIFO).
+// Хранится в Room.hands и меняется только в горутине комнаты.
+
+// Обработка raise-hand: участник встает в конец очереди; повторный raise-hand место не меняет
+func (h *Hub) handleRaiseHand(client *Client, msg Message) {
+	if client.RoomID == "" {
+		client.sendError("not-in-room", "join a room before raising a hand")
+		return
+	}
+
+	h.inClientRoom(client, msg.Type, func(room *Room) {
+		if slices.Contains(room.hands, client.ID) {
+			return
+		}
+		room.hands = append(room.hands, client.ID)
+		logger.Debug("Участник поднял руку", "client_id", client.ID, "room_id", room.ID, "position", len(room.hands))
+		broadcastHands(room)
+	})
+}
+
+// Обработка lower-hand: участник опускает свою руку, ведущий может опустить руку любого участника (to)
+func (h *Hub) handleLowerHand(client *Client, msg Message) {
+	if client.RoomID == "" {
+		client.sendError("not-in-room", "join a room before lowering a hand")
+		return
+	}
+
+	h.inClientRoom(client, msg.Type, func(room *Room) {
+		target := client.ID
+		if msg.To != "" && msg.To != client.ID {
+			if room.HostID != client.ID {
+				client.sendError("not-host", "only the room host can lower other participants' hands")
+				return
+			}
+			target = msg.To
+		}
+		if lowerHand(room, target) {
+			logger.Debug("Участник опустил руку", "client_id", target, "room_id", room.ID, "by", client.ID)
+		}
+	})
+}
+
+// Удаление участника из очереди с рассылкой нового порядка; вызывается в горутине комнаты.
+// false — рука участника не была поднята.
+func lowerHand(room *Room, clientID string) bool {
+	i := slices.Index(room.hands, clientID)
+	if i < 0 {
+		return false
+	}
+	room.hands = slices.Delete(room.hands, i, i+1)
+	broadcastHands(room)
+	return true
+}
+
+// Рассылка текущей очереди всем участникам; вызывается в горутине комнаты
+func broadcastHands(room *Room) {
+	update := Message{
+		Type:   "hands-updated",
+		RoomID: room.ID,
+		Hands:  roomHands(room),
+	}
+	for _, c := range room.Clients {
+		c.send(update)
+	}
+}
+
+// Копия очереди для сообщения: оно сериализуется в writePump, когда очередь уже может измениться
+func roomHands(room *Room) []string {
+	return slices.Clone(room.hands)
+}
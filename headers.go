@@ -0,0 +1,32 @@
+package main
+
+import "net/http"
+
+// Максимальная длина значения заголовка, передаваемого участникам
+const maxRelayHeaderLength = 256
+
+// Заголовки, которые не передаются участникам, даже если указаны в RELAY_HEADERS
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Sec-Websocket-Key":   true,
+}
+
+// Заголовки запроса подключения из RELAY_HEADERS для списка участников и user-joined.
+// Передаются только явно разрешенные заголовки; nil — ни одного нет.
+func relayHeadersFrom(r *http.Request) map[string]string {
+	var headers map[string]string
+	for _, name := range relayHeaders {
+		name = http.CanonicalHeaderKey(name)
+		value := r.Header.Get(name)
+		if value == "" || sensitiveHeaders[name] {
+			continue
+		}
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+		headers[name] = truncatePayload(value, maxRelayHeaderLength)
+	}
+	return headers
+}
@@ -0,0 +1,1091 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Состояние сервера: комнаты, соединения и подписчики admin событий.
+//
+// Состояние каждой комнаты принадлежит ее горутине (Room.do), поэтому мьютекса комнаты нет.
+// Порядок блокировок: roomsMu → горутина комнаты → client.mu. Мьютексы подписчиков admin
+// событий, реестра соединений и лимитеров — листовые: под ними другие блокировки не берутся.
+// roomsMu держится только для поиска, создания и удаления комнат; для чтения комнат
+// используются room и roomList, которые отпускают roomsMu до работы с комнатой.
+// Команда в горутине комнаты не берет roomsMu и не вызывает do (ни своей, ни чужой комнаты)
+// и не блокируется на канале: отправка клиенту идет только через неблокирующий Client.send.
+// Join, выход и пересылка выполняются горутиной строго по одной, поэтому каждый участник
+// видит события комнаты в порядке их применения.
+type Hub struct {
+	upgrader websocket.Upgrader
+
+	// Комнаты и их участники; операции с комнатами выполняются под roomsMu
+	rooms   RoomStore
+	roomsMu sync.Mutex
+
+	// Все активные WebSocket соединения (в том числе не вошедшие в комнату)
+	clients   map[*Client]bool
+	clientsMu sync.Mutex
+	clientsWG sync.WaitGroup
+
+	// Подписчики на события жизненного цикла комнат (/admin/events)
+	adminSubscribers   map[*Client]bool
+	adminSubscribersMu sync.Mutex
+
+	// Сессии long polling по секретному ID сессии
+	pollSessions   map[string]*pollSession
+	pollSessionsMu sync.Mutex
+
+	// Очередь событий для WEBHOOK_URL
+	webhooks chan WebhookEvent
+
+	// Пересылка между инстансами через Redis; nil — один инстанс
+	cluster *clusterRelay
+
+	// Ограничение частоты и количества соединений с одного IP
+	connLimiter *ipLimiter
+
+	// Ограничение создания комнат с одного IP
+	roomLimiter *roomCreationLimiter
+
+	// Число подключенных клиентов по всему серверу для лимита MAX_CLIENTS
+	activeClients atomic.Int64
+
+	// Сервер принимает соединения; выставляется после открытия listener
+	ready atomic.Bool
+
+	// Сервер останавливается; выставляется по сигналу завершения
+	shuttingDown atomic.Bool
+
+	// Время запуска сервера для расчета uptime
+	startTime time.Time
+}
+
+// Создание hub с настройками из окружения
+func newHub() *Hub {
+	return &Hub{
+		upgrader: websocket.Upgrader{
+			CheckOrigin:       checkOrigin,
+			EnableCompression: enableCompression,
+			ReadBufferSize:    readBufferSize,
+			WriteBufferSize:   writeBufferSize,
+			// Буферы записи берутся из пула только на время записи, а не держатся каждым соединением
+			WriteBufferPool: &sync.Pool{},
+		},
+		rooms:            newMemoryRoomStore(),
+		clients:          make(map[*Client]bool),
+		adminSubscribers: make(map[*Client]bool),
+		pollSessions:     make(map[string]*pollSession),
+		webhooks:         make(chan WebhookEvent, webhookQueueSize),
+		connLimiter:      newIPLimiter(connRatePerMinute, maxConnsPerIP),
+		roomLimiter:      newRoomCreationLimiter(maxRoomsPerIP, roomsPerIPWindow),
+		startTime:        time.Now(),
+	}
+}
+
+// Поиск комнаты по ключу; roomsMu отпускается до возврата
+func (h *Hub) room(key string) *Room {
+	h.roomsMu.Lock()
+	defer h.roomsMu.Unlock()
+	return h.rooms.GetRoom(key)
+}
+
+// Комната, в которой состоит клиент, с учетом его арендатора
+func (h *Hub) clientRoom(client *Client) *Room {
+	return h.room(roomKey(client.Tenant, client.RoomID))
+}
+
+// Выполнение fn в горутине комнаты клиента, если клиент в ней состоит.
+// Иначе клиент получает not-in-room, и возвращается false.
+func (h *Hub) inClientRoom(client *Client, msgType string, fn func(room *Room)) bool {
+	room := h.clientRoom(client)
+	member := false
+	if room != nil {
+		room.do(func() {
+			if member = room.has(client); member {
+				fn(room)
+			}
+		})
+	}
+	if !member {
+		logger.Warn("Клиент не состоит в комнате", "client_id", client.ID, "room_id", client.RoomID, "msg_type", msgType)
+		client.sendError("not-in-room", "sender is not a member of the room")
+	}
+	return member
+}
+
+// Ключ комнаты в карте комнат: "tenant:roomId" или просто roomId без арендатора.
+// Арендатор не содержит ":", поэтому ключи разных арендаторов не пересекаются.
+func roomKey(tenant, roomID string) string {
+	if tenant == "" {
+		return roomID
+	}
+	return tenant + ":" + roomID
+}
+
+// Арендатор соединения согласно TENANT_SOURCE; ":" заменяется, чтобы ключ комнаты разбирался однозначно
+func tenantFromRequest(r *http.Request) string {
+	var tenant string
+	switch tenantSource {
+	case "origin":
+		if origin, err := url.Parse(r.Header.Get("Origin")); err == nil {
+			tenant = strings.ToLower(origin.Host)
+		}
+	case "query":
+		tenant = r.URL.Query().Get("tenant")
+	}
+	return strings.ReplaceAll(tenant, ":", "_")
+}
+
+// Снимок списка комнат для обхода без удержания roomsMu
+func (h *Hub) roomList() []*Room {
+	h.roomsMu.Lock()
+	defer h.roomsMu.Unlock()
+
+	return h.rooms.Rooms()
+}
+
+// Завершение соединения клиента — единственная точка очистки при отключении.
+// Повторные вызовы безопасны: удаление из комнаты и закрытие отправки выполняются один раз.
+// Отмена контекста клиента останавливает writePump, не дожидаясь разбора очереди.
+func (h *Hub) teardown(c *Client) {
+	if !c.removed.CompareAndSwap(false, true) {
+		return
+	}
+
+	// При разрыве соединения даем клиенту время переподключиться;
+	// при остановке сервера ждать переподключения бессмысленно
+	if c.RoomID != "" {
+		if h.shuttingDown.Load() {
+			h.Leave(c, leaveReasonShutdown)
+		} else {
+			h.suspendClient(c)
+		}
+	}
+	c.closeSend()
+	c.cancel()
+}
+
+// Проверка типа входящего сообщения по списку разрешенных; в строгом режиме нарушитель отключается
+func (h *Hub) checkMessageType(client *Client, msgType string) bool {
+	if messageTypeAllowed(msgType) {
+		return true
+	}
+
+	logger.Warn("Неподдерживаемый тип сообщения", "client_id", client.ID, "room_id", client.RoomID, "msg_type", msgType, "strict", strictMessageTypes)
+	if strictMessageTypes {
+		client.closeWith(websocket.ClosePolicyViolation, "unsupported message type")
+	}
+	return false
+}
+
+// Обработка присоединения к комнате
+func (h *Hub) Join(client *Client, msg Message) {
+	// Комната из URL подключения: join без roomId входит в нее, другой roomId отклоняется
+	if client.URLRoomID != "" {
+		if msg.RoomID != "" && msg.RoomID != client.URLRoomID {
+			logger.Warn("Отклонен join: roomId не совпадает с URL", "client_id", client.ID, "room_id", msg.RoomID, "url_room_id", client.URLRoomID)
+			client.send(Message{
+				Type:   "join-rejected",
+				RoomID: msg.RoomID,
+				Reason: "room-mismatch",
+			})
+			return
+		}
+		msg.RoomID = client.URLRoomID
+	}
+
+	// Если roomId пустой, игнорируем это сообщение
+	if msg.RoomID == "" {
+		logger.Warn("Получен join без roomId", "client_id", client.ID)
+		return
+	}
+
+	// Без from join допустим, только если сервер выдает ID сам (или ID взят из JWT)
+	if msg.From == "" && client.AuthID == "" && !assignClientIDs {
+		logger.Warn("Отклонен join без from", "client_id", client.ID, "room_id", msg.RoomID)
+		client.send(Message{
+			Type:   "join-rejected",
+			RoomID: msg.RoomID,
+			Reason: "missing-from",
+		})
+		return
+	}
+
+	if msg.ProtocolVersion != 0 && (msg.ProtocolVersion < minProtocolVersion || msg.ProtocolVersion > maxProtocolVersion) {
+		logger.Warn("Отклонен join: неподдерживаемая версия протокола", "client_id", client.ID, "room_id", msg.RoomID, "protocol_version", msg.ProtocolVersion)
+		client.send(Message{
+			Type:               "join-rejected",
+			RoomID:             msg.RoomID,
+			Reason:             "unsupported-version",
+			MinProtocolVersion: minProtocolVersion,
+			MaxProtocolVersion: maxProtocolVersion,
+		})
+		return
+	}
+
+	username, err := sanitizeUsername(msg.Username)
+	if err != nil {
+		logger.Warn("Отклонен join: некорректное имя", "client_id", client.ID, "room_id", msg.RoomID, "error", err)
+		client.send(Message{
+			Type:   "join-rejected",
+			RoomID: msg.RoomID,
+			Reason: "invalid-username",
+			Limit:  maxUsernameLength,
+		})
+		return
+	}
+
+	// Повторный join в ту же комнату отклоняется: выход и вход заново разослали бы
+	// user-left/user-joined, могли бы передать роль ведущего, а последний участник
+	// пересоздал бы комнату без пароля
+	if client.RoomID != "" && roomKey(client.Tenant, client.RoomID) == roomKey(client.Tenant, msg.RoomID) {
+		client.sendError("already-in-room", "already joined this room")
+		return
+	}
+
+	// Одно соединение — одна комната: сначала выходим из текущей (с user-left),
+	// иначе клиент остался бы в старой комнате навсегда
+	if client.RoomID != "" {
+		logger.Info("Повторный join, выход из текущей комнаты", "client_id", client.ID, "room_id", client.RoomID, "new_room_id", msg.RoomID)
+		h.Leave(client, leaveReasonLeave)
+		client.RoomID = ""
+	}
+
+	// Без from используем ID, выданный сервером при подключении
+	client.ID = msg.From
+	if client.ID == "" {
+		client.ID = client.AssignedID
+	}
+	client.Username = username
+
+	// Аутентифицированному клиенту доверяем только claims из токена
+	if client.AuthID != "" {
+		client.ID = client.AuthID
+		if client.AuthUsername != "" {
+			client.Username = client.AuthUsername
+		}
+	}
+
+	// Комнату могут удалить между поиском и входом: тогда ее горутина уже остановлена,
+	// do вернет false, и комната ищется (или создается) заново
+	key := roomKey(client.Tenant, msg.RoomID)
+	for {
+		room, exists := h.findOrCreateRoom(client, msg, key)
+		if room == nil {
+			return
+		}
+		if room.do(func() { h.enterRoom(room, client, msg, exists) }) {
+			return
+		}
+	}
+}
+
+// Поиск комнаты для join или создание новой с проверкой лимитов.
+// nil — комната не создана, клиент уже получил отказ. exists — комната существовала до join.
+func (h *Hub) findOrCreateRoom(client *Client, msg Message, key string) (*Room, bool) {
+	h.roomsMu.Lock()
+	defer h.roomsMu.Unlock()
+
+	if room := h.rooms.GetRoom(key); room != nil {
+		return room, true
+	}
+	if maxRooms > 0 && h.rooms.RoomCount() >= maxRooms {
+		logger.Warn("Достигнут лимит комнат, новая комната не создана", "client_id", client.ID, "room_id", msg.RoomID, "limit", maxRooms)
+		client.send(Message{
+			Type:   "server-capacity",
+			RoomID: msg.RoomID,
+			Limit:  maxRooms,
+		})
+		return nil, false
+	}
+	if !h.roomLimiter.allow(client.IP) {
+		logger.Warn("Превышен лимит создания комнат с IP", "client_id", client.ID, "room_id", msg.RoomID, "ip", client.IP, "limit", maxRoomsPerIP)
+		client.send(Message{
+			Type:   "rate-limited",
+			RoomID: msg.RoomID,
+			Reason: "too many rooms created from this address",
+			Limit:  maxRoomsPerIP,
+		})
+		return nil, false
+	}
+
+	room := h.rooms.CreateRoom(msg.RoomID, key)
+	if msg.Password != "" {
+		room.setPassword(msg.Password)
+	}
+	room.metadata = msg.Metadata
+	h.watchInactivity(room)
+	h.watchDuration(room)
+	activeRoomsGauge.Inc()
+	logger.Info("Создана новая комната", "room_id", key)
+	h.emitAdminEvent(Message{Type: "room-created", RoomID: key})
+	return room, false
+}
+
+// Вход клиента в комнату; выполняется в горутине комнаты
+func (h *Hub) enterRoom(room *Room, client *Client, msg Message, exists bool) {
+	// Защищенная паролем комната: без верного пароля не входит никто, включая переподключения
+	if exists && !room.checkPassword(msg.Password) {
+		logger.Warn("Неверный пароль комнаты", "client_id", client.ID, "room_id", msg.RoomID)
+		client.send(Message{
+			Type:   "auth-failed",
+			RoomID: msg.RoomID,
+			Reason: "invalid room password",
+		})
+		return
+	}
+
+	// Переподключение в пределах grace периода: восстанавливаем без user-left/user-joined.
+	// Место ожидающего клиента занимает только владелец: по токену из joined или по тому же
+	// ID из JWT. Иначе любой, кто знает ID, перехватил бы чужой сеанс на весь grace период
+	if pending := room.Pending[client.ID]; pending != nil {
+		if !pending.ownedBy(client, msg.Token) {
+			logger.Warn("Отклонен join: ID ожидает переподключения", "client_id", client.ID, "room_id", msg.RoomID)
+			client.send(Message{
+				Type:   "join-rejected",
+				RoomID: msg.RoomID,
+				Reason: "duplicate-id",
+			})
+			return
+		}
+
+		pending.timer.Stop()
+		delete(room.Pending, client.ID)
+		client.RoomID = msg.RoomID
+		client.resumeToken = newResumeToken(room.key)
+		// Состояние медиа сохраняется между переподключениями, как и при resume
+		client.mu.Lock()
+		client.media = pending.Media
+		client.mu.Unlock()
+		h.rooms.AddClient(room, client)
+
+		// Имя из нового join могло измениться: остальные узнают о нем, как при rename
+		if client.Username != pending.Username {
+			notification := Message{
+				Type:     "user-renamed",
+				From:     client.ID,
+				Username: client.Username,
+				RoomID:   room.ID,
+			}
+			for _, otherClient := range room.Clients {
+				if otherClient != client {
+					otherClient.send(notification)
+				}
+			}
+		}
+
+		client.send(Message{
+			Type:               "joined",
+			RoomID:             client.RoomID,
+			Participants:       roomParticipants(room, client),
+			Resumed:            true,
+			HostID:             room.HostID,
+			Metadata:           room.metadata,
+			Token:              client.resumeToken,
+			ICEServers:         stunServers(),
+			Hands:              roomHands(room),
+			ExpiresAt:          room.expiresAt(),
+			MinProtocolVersion: minProtocolVersion,
+			MaxProtocolVersion: maxProtocolVersion,
+		})
+
+		logger.Info("Клиент переподключился к комнате", "client_id", client.ID, "room_id", client.RoomID, "username", client.Username, client.connAttr())
+		return
+	}
+
+	// Клиент с таким же ID уже в комнате: отклоняем join или вытесняем старого
+	if existing := room.Clients[client.ID]; existing != nil && existing != client {
+		if duplicateIDPolicy != "replace" {
+			logger.Warn("Отклонен join: ID уже занят", "client_id", client.ID, "room_id", msg.RoomID)
+			client.send(Message{
+				Type:   "join-rejected",
+				RoomID: msg.RoomID,
+				Reason: "duplicate-id",
+			})
+			return
+		}
+
+		h.detachClient(room, existing, leaveReasonReplaced)
+		existing.send(Message{
+			Type:   "replaced",
+			RoomID: msg.RoomID,
+			Reason: "duplicate-id",
+		})
+		existing.closeWith(websocket.ClosePolicyViolation, "replaced")
+		logger.Info("Клиент вытеснен новым соединением с тем же ID", "client_id", existing.ID, "room_id", msg.RoomID)
+	}
+
+	// Проверка вместимости в горутине комнаты, поэтому одновременные join не превысят лимит.
+	// Места клиентов, ожидающих переподключения, остаются за ними
+	if maxRoomClients > 0 && len(room.Clients)+len(room.Pending) >= maxRoomClients {
+		logger.Warn("Комната заполнена, клиент не добавлен", "client_id", client.ID, "room_id", msg.RoomID, "limit", maxRoomClients)
+		client.send(Message{
+			Type:   "room-full",
+			RoomID: msg.RoomID,
+			Limit:  maxRoomClients,
+		})
+		return
+	}
+
+	// Добавляем клиента в комнату; первый вошедший становится ведущим
+	client.RoomID = msg.RoomID
+	client.resumeToken = newResumeToken(room.key)
+	h.rooms.AddClient(room, client)
+	if room.HostID == "" {
+		room.HostID = client.ID
+	}
+
+	// Webhook о создании уходит после входа создателя, чтобы participantCount его учитывал.
+	// В горутине комнаты он гарантированно опережает room-destroyed
+	if !exists {
+		h.queueWebhook("room-created", room.key, len(room.Clients))
+	}
+
+	// Подтверждение ставим в очередь новичка до уведомления остальных:
+	// иначе offer от участника, получившего user-joined, мог бы опередить joined.
+	// Все отправки неблокирующие, поэтому горутина комнаты здесь не застопорится.
+	response := Message{
+		Type:               "joined",
+		RoomID:             client.RoomID,
+		Participants:       roomParticipants(room, client),
+		HostID:             room.HostID,
+		Metadata:           room.metadata,
+		Token:              client.resumeToken,
+		ICEServers:         stunServers(),
+		Hands:              roomHands(room),
+		ExpiresAt:          room.expiresAt(),
+		MinProtocolVersion: minProtocolVersion,
+		MaxProtocolVersion: maxProtocolVersion,
+	}
+	if msg.From == "" && client.AuthID == "" {
+		response.AssignedID = client.ID
+	}
+	client.send(response)
+
+	// Уведомляем ВСЕХ других участников о новом пользователе: сразу или одним users-joined за окно
+	if joinNotifyWindow > 0 {
+		queueJoinNotification(room, client)
+	} else {
+		notifyUserJoined(room, client)
+	}
+	h.publishRemote(room, Message{
+		Type:     "user-joined",
+		From:     client.ID,
+		Username: client.Username,
+		RoomID:   room.ID,
+		Headers:  client.Headers,
+	})
+
+	logger.Info("Клиент присоединился к комнате", "client_id", client.ID, "room_id", client.RoomID, "username", client.Username, client.connAttr())
+	h.emitAdminEvent(Message{
+		Type:     "user-joined",
+		From:     client.ID,
+		Username: client.Username,
+		RoomID:   room.key,
+	})
+}
+
+// Восстановление в комнате по токену переподключения из joined.
+// Токен действует только в пределах grace периода и сгорает после использования:
+// новый токен выдается в ответе joined.
+func (h *Hub) Resume(client *Client, msg Message) {
+	if client.RoomID != "" {
+		client.sendError("already-in-room", "resume is only allowed before joining a room")
+		return
+	}
+
+	reject := func() {
+		logger.Warn("Отклонен resume: токен недействителен", "client_id", client.ID)
+		client.send(Message{Type: "resume-failed", Reason: "invalid or expired token"})
+	}
+
+	_, key, ok := strings.Cut(msg.Token, ".")
+	if !ok || key == "" {
+		reject()
+		return
+	}
+
+	room := h.room(key)
+	// Токен одного арендатора не открывает комнату другого
+	if room == nil || room.key != roomKey(client.Tenant, room.ID) {
+		reject()
+		return
+	}
+
+	resumed := false
+	room.do(func() {
+		// После смены сети старое соединение может числиться живым до истечения pongWait:
+		// токен тогда принадлежит подключенному участнику, а не ожидающему
+		pending := room.pendingByToken(msg.Token)
+		var old *Client
+		id := ""
+		if pending != nil {
+			id = pending.ID
+		} else if old = room.clientByToken(msg.Token); old != nil {
+			id = old.ID
+		}
+		// Аутентифицированный клиент может восстановить только свою идентичность
+		if id == "" || (client.AuthID != "" && client.AuthID != id) {
+			return
+		}
+
+		var username string
+		var media *MediaState
+		if pending != nil {
+			pending.timer.Stop()
+			delete(room.Pending, pending.ID)
+			username, media = pending.Username, pending.Media
+		} else {
+			// Старое соединение закрывается без user-left и user-joined: для остальных участник не менялся
+			h.rooms.RemoveClient(room, old.ID)
+			username, media = old.Username, old.mediaState()
+			old.closeWith(websocket.CloseNormalClosure, "resumed")
+			logger.Info("Старое соединение заменено восстановленным", "client_id", old.ID, "room_id", room.ID)
+		}
+
+		client.ID = id
+		client.RoomID = room.ID
+		client.resumeToken = newResumeToken(room.key)
+		client.mu.Lock()
+		client.Username = username
+		client.media = media
+		client.mu.Unlock()
+		h.rooms.AddClient(room, client)
+
+		client.send(Message{
+			Type:               "joined",
+			RoomID:             room.ID,
+			Username:           client.Username,
+			Participants:       roomParticipants(room, client),
+			Resumed:            true,
+			HostID:             room.HostID,
+			Metadata:           room.metadata,
+			Token:              client.resumeToken,
+			ICEServers:         stunServers(),
+			Hands:              roomHands(room),
+			ExpiresAt:          room.expiresAt(),
+			MinProtocolVersion: minProtocolVersion,
+			MaxProtocolVersion: maxProtocolVersion,
+		})
+		resumed = true
+	})
+	if !resumed {
+		reject()
+		return
+	}
+
+	logger.Info("Клиент восстановлен по токену", "client_id", client.ID, "room_id", room.ID, "username", client.Username, client.connAttr())
+}
+
+// Обработка signaling сообщений (offer, answer, ice-candidate, request-offer).
+// request-offer — просьба новичка к участнику (или без получателя — ко всем) прислать ему offer.
+// Сообщения одного отправителя одному получателю доставляются в порядке отправки:
+// они проходят через один read loop, один буфер Send и один writePump.
+// seq ведется для каждой пары отправитель — получатель и позволяет получателю
+// обнаружить пропуски, если его буфер переполнился.
+func (h *Hub) Signal(client *Client, msg Message) {
+	// Signaling до входа в комнату не допускается
+	if client.RoomID == "" {
+		logger.Warn("Signaling от клиента, не вошедшего в комнату", "client_id", client.ID, "msg_type", msg.Type)
+		client.sendError("not-in-room", "join a room before sending signaling messages")
+		return
+	}
+
+	// Аномально большой SDP или ICE candidate отклоняется даже в пределах лимита фрейма
+	if maxSignalingPayload > 0 && len(msg.Data) > maxSignalingPayload {
+		logger.Warn("Слишком большой payload signaling сообщения", "client_id", client.ID, "room_id", client.RoomID, "msg_type", msg.Type, "payload_bytes", len(msg.Data), "limit", maxSignalingPayload)
+		client.send(Message{
+			Type:   "error",
+			Code:   "payload-too-large",
+			Reason: msg.Type + " data exceeds the size limit",
+			Limit:  maxSignalingPayload,
+		})
+		return
+	}
+
+	if strictValidation {
+		if err := validateSignalingPayload(msg.Type, msg.Data); err != nil {
+			logger.Warn("Невалидный payload signaling сообщения", "client_id", client.ID, "room_id", client.RoomID, "msg_type", msg.Type, "error", err)
+			client.sendError("invalid-payload", err.Error())
+			return
+		}
+	}
+
+	// request-offer без получателя адресован всем остальным участникам комнаты
+	toAll := msg.Type == "request-offer" && msg.To == "" && len(msg.ToList) == 0
+	if msg.To == "" && len(msg.ToList) == 0 && !toAll {
+		logger.Warn("Сообщение без получателя", "client_id", client.ID, "room_id", client.RoomID, "msg_type", msg.Type)
+		return
+	}
+
+	// Отправитель должен состоять в комнате, через которую идет пересылка.
+	// Получатели определяются в горутине комнаты, отправка идет после выхода из нее.
+	var relayRoom *Room
+	var targets []*Client
+	var copies []Message
+	var remote []Message
+	h.inClientRoom(client, msg.Type, func(room *Room) {
+		relayRoom = room
+		room.touch()
+
+		// Добавляем информацию об отправителе; seq присваивается каждой копии после поиска получателя
+		msg.From = client.ID
+
+		recipients := msg.ToList
+		if len(recipients) == 0 {
+			recipients = []string{msg.To}
+		}
+		msg.ToList = nil
+		if toAll {
+			recipients = recipients[:0]
+			for id := range room.Clients {
+				if id != client.ID {
+					recipients = append(recipients, id)
+				}
+			}
+			// Участники на других инстансах получат копию без To и без seq
+			if h.cluster != nil {
+				remote = append(remote, msg)
+			}
+		}
+
+		// toList: каждый получатель получает свою копию с To, равным его ID
+		seen := make(map[string]bool, len(recipients))
+		for _, to := range recipients {
+			if to == "" || seen[to] {
+				continue
+			}
+			seen[to] = true
+			msg.To = to
+			// С Redis получателя, которого нет на этом инстансе, ищут остальные инстансы
+			if h.cluster != nil && to != client.ID && room.Clients[to] == nil {
+				msg.Seq = room.nextSeq(client.ID, to)
+				remote = append(remote, msg)
+				continue
+			}
+			if target := signalingTarget(room, client, msg); target != nil {
+				msg.Seq = room.nextSeq(client.ID, to)
+				targets = append(targets, target)
+				copies = append(copies, msg)
+			}
+		}
+	})
+
+	for i, target := range targets {
+		relaySignaling(relayRoom, target, copies[i])
+	}
+	for _, m := range remote {
+		logPayload(relayRoom.ID, m)
+		h.publishRemote(relayRoom, m)
+	}
+}
+
+// Обработка сообщения чата: рассылка всем участникам комнаты, кроме отправителя
+func (h *Hub) handleChat(client *Client, msg Message) {
+	h.broadcastToRoom(client, Message{
+		Type:     msg.Type,
+		Username: client.Username,
+		Data:     msg.Data,
+		Echo:     msg.Echo,
+	})
+}
+
+// Обработка broadcast: произвольный payload для всех участников комнаты без указания To
+func (h *Hub) handleBroadcast(client *Client, msg Message) {
+	h.broadcastToRoom(client, Message{
+		Type: msg.Type,
+		Data: msg.Data,
+		Echo: msg.Echo,
+	})
+}
+
+// Обработка typing: индикатор набора текста рассылается комнате без хранения на сервере
+func (h *Hub) handleTyping(client *Client, msg Message) {
+	if msg.Typing == nil {
+		client.sendError("invalid-payload", "typing requires a boolean typing field")
+		return
+	}
+
+	h.broadcastToRoom(client, Message{
+		Type:   "typing",
+		From:   client.ID,
+		RoomID: client.RoomID,
+		Typing: msg.Typing,
+	})
+}
+
+// Обработка media-state: сохраняем состояние микрофона/камеры и рассылаем комнате
+func (h *Hub) handleMediaState(client *Client, msg Message) {
+	if client.RoomID == "" {
+		client.sendError("not-in-room", "join a room before sending media state")
+		return
+	}
+
+	var state MediaState
+	if err := json.Unmarshal(msg.Data, &state); err != nil {
+		logger.Warn("Некорректный media-state", "client_id", client.ID, "room_id", client.RoomID, "error", err)
+		client.sendError("invalid-payload", "media-state data must be {audio: bool, video: bool}")
+		return
+	}
+
+	client.mu.Lock()
+	client.media = &state
+	client.mu.Unlock()
+
+	data, _ := json.Marshal(state)
+	h.broadcastToRoom(client, Message{
+		Type:   "media-state",
+		From:   client.ID,
+		RoomID: client.RoomID,
+		Data:   data,
+	})
+}
+
+// Обработка rename: смена отображаемого имени без переподключения.
+// Имя меняется в горутине комнаты, поэтому roster для новых участников уже содержит новое имя.
+func (h *Hub) handleRename(client *Client, msg Message) {
+	if client.RoomID == "" {
+		client.sendError("not-in-room", "join a room before renaming")
+		return
+	}
+
+	// Имя аутентифицированного клиента задается токеном
+	if client.AuthUsername != "" {
+		client.sendError("rename-forbidden", "username is set by the auth token")
+		return
+	}
+
+	username, err := sanitizeUsername(msg.Username)
+	if err != nil {
+		client.sendError("invalid-username", err.Error())
+		return
+	}
+
+	h.inClientRoom(client, msg.Type, func(room *Room) {
+		client.mu.Lock()
+		client.Username = username
+		client.mu.Unlock()
+
+		notification := Message{
+			Type:     "user-renamed",
+			From:     client.ID,
+			Username: username,
+			RoomID:   room.ID,
+		}
+		for _, otherClient := range room.Clients {
+			if otherClient != client {
+				otherClient.send(notification)
+			}
+		}
+		logger.Info("Клиент сменил имя", "client_id", client.ID, "room_id", room.ID, "username", username)
+	})
+}
+
+// Рассылка сообщения всем участникам комнаты клиента; отправителю — только при эхо
+func (h *Hub) broadcastToRoom(client *Client, msg Message) {
+	if client.RoomID == "" {
+		logger.Warn("Сообщение от клиента, не вошедшего в комнату", "client_id", client.ID, "msg_type", msg.Type)
+		client.sendError("not-in-room", "join a room before sending "+msg.Type+" messages")
+		return
+	}
+
+	// Эхо отправителю: по флагу сообщения или для типов из ECHO_TYPES
+	echo := msg.Echo || slices.Contains(echoTypes, msg.Type)
+
+	// Рассылается только белый список полей: служебные поля (hostId, participants, code и т.п.)
+	// от клиента не пересылаются, From и RoomID задает сервер
+	out := Message{
+		Type:     msg.Type,
+		From:     client.ID,
+		RoomID:   client.RoomID,
+		Username: msg.Username,
+		Data:     msg.Data,
+		Typing:   msg.Typing,
+	}
+
+	h.inClientRoom(client, msg.Type, func(room *Room) {
+		room.touch()
+		logPayload(room.ID, out)
+		for _, otherClient := range room.Clients {
+			if otherClient == client && !echo {
+				continue
+			}
+			out.Seq = room.nextSeq(client.ID, otherClient.ID)
+			if otherClient.send(out) {
+				room.relayed.Add(1)
+			}
+		}
+	})
+}
+
+// Обработка выхода из комнаты. Соединение остается открытым: после leave клиент не состоит
+// ни в одной комнате, и signaling до следующего join отклоняется как not-in-room.
+func (h *Hub) handleLeave(client *Client, msg Message) {
+	h.Leave(client, leaveReasonLeave)
+	client.RoomID = ""
+	client.resumeToken = ""
+}
+
+// Удаление клиента из комнаты; reason передается остальным участникам в user-left
+func (h *Hub) Leave(client *Client, reason string) {
+	if client.RoomID == "" {
+		return
+	}
+
+	room := h.clientRoom(client)
+
+	if room == nil {
+		return
+	}
+
+	// Клиент мог быть уже удален (например, вытеснен клиентом с тем же ID)
+	detached := false
+	room.do(func() {
+		if detached = room.has(client); detached {
+			h.detachClient(room, client, reason)
+		}
+	})
+	if !detached {
+		return
+	}
+
+	logger.Info("Клиент покинул комнату", "client_id", client.ID, "room_id", client.RoomID, "username", client.Username, "reason", reason, client.connAttr())
+
+	h.deleteRoomIfEmpty(room)
+}
+
+// Удаление клиента из комнаты с уведомлением остальных; вызывается в горутине комнаты
+func (h *Hub) detachClient(room *Room, client *Client, reason string) {
+	h.rooms.RemoveClient(room, client.ID)
+	h.notifyUserLeft(room, client.ID, reason)
+	reassignHost(room)
+}
+
+// Уведомление участников комнаты и admin подписчиков о выходе; вызывается в горутине комнаты
+func (h *Hub) notifyUserLeft(room *Room, clientID, reason string) {
+	notification := Message{
+		Type:   "user-left",
+		From:   clientID,
+		RoomID: room.ID,
+		Reason: reason,
+	}
+	for _, otherClient := range room.Clients {
+		otherClient.send(notification)
+	}
+	h.publishRemote(room, notification)
+	// Вышедший покидает и очередь поднятых рук
+	lowerHand(room, clientID)
+	room.forgetSeq(clientID)
+	notification.RoomID = room.key
+	h.emitAdminEvent(notification)
+}
+
+// Обработка исключения участника ведущим комнаты
+func (h *Hub) handleKick(client *Client, msg Message) {
+	if client.RoomID == "" {
+		client.sendError("not-in-room", "join a room before kicking participants")
+		return
+	}
+
+	h.inClientRoom(client, msg.Type, func(room *Room) {
+		if room.HostID != client.ID {
+			logger.Warn("Попытка kick не от ведущего", "client_id", client.ID, "room_id", client.RoomID, "to", msg.To)
+			client.sendError("not-host", "only the room host can kick participants")
+			return
+		}
+
+		if msg.To == "" || msg.To == client.ID {
+			client.sendError("invalid-target", "kick requires another participant in to")
+			return
+		}
+
+		if target := room.Clients[msg.To]; target != nil {
+			h.detachClient(room, target, leaveReasonKick)
+
+			// writePump доставит kicked и закроет соединение
+			target.send(Message{
+				Type:   "kicked",
+				From:   client.ID,
+				RoomID: room.ID,
+			})
+			target.closeWith(websocket.ClosePolicyViolation, "kicked")
+		} else if pending := room.Pending[msg.To]; pending != nil {
+			pending.timer.Stop()
+			delete(room.Pending, msg.To)
+			h.notifyUserLeft(room, msg.To, leaveReasonKick)
+		} else {
+			client.sendError("peer-not-found", "participant not found in the room")
+			return
+		}
+
+		logger.Info("Участник исключен ведущим", "client_id", msg.To, "room_id", room.ID, "host_id", client.ID)
+	})
+}
+
+// Перевод отключившегося клиента в ожидание переподключения.
+// Если за reconnectGrace клиент с тем же ID не вернется, он удаляется из комнаты.
+func (h *Hub) suspendClient(client *Client) {
+	if reconnectGrace <= 0 {
+		h.Leave(client, leaveReasonDisconnect)
+		return
+	}
+
+	room := h.clientRoom(client)
+
+	if room == nil {
+		return
+	}
+
+	room.do(func() {
+		if !room.has(client) {
+			return
+		}
+		h.rooms.RemoveClient(room, client.ID)
+
+		pending := &pendingClient{
+			ID:       client.ID,
+			Username: client.Username,
+			Media:    client.mediaState(),
+			Headers:  client.Headers,
+			token:    client.resumeToken,
+		}
+		pending.timer = time.AfterFunc(reconnectGrace, func() {
+			h.expirePending(room, pending)
+		})
+		room.Pending[client.ID] = pending
+
+		logger.Info("Клиент отключился, ожидание переподключения", "client_id", client.ID, "room_id", room.ID, "grace", reconnectGrace.String(), client.connAttr())
+	})
+}
+
+// Окончательное удаление клиента, не переподключившегося за grace период
+func (h *Hub) expirePending(room *Room, pending *pendingClient) {
+	// Клиент мог уже переподключиться
+	expired := false
+	room.do(func() {
+		if expired = room.Pending[pending.ID] == pending; !expired {
+			return
+		}
+		delete(room.Pending, pending.ID)
+		h.notifyUserLeft(room, pending.ID, leaveReasonDisconnect)
+		reassignHost(room)
+	})
+	if !expired {
+		return
+	}
+
+	logger.Info("Клиент покинул комнату (не переподключился)", "client_id", pending.ID, "room_id", room.ID)
+
+	h.deleteRoomIfEmpty(room)
+}
+
+// Удаление комнаты без подключенных и ожидающих переподключения клиентов.
+// Проверка повторяется в горутине комнаты под h.roomsMu, чтобы не удалить комнату, в которую входит новый клиент:
+// join, уже дождавшийся очереди, выполнится до проверки, а следующие получат отказ от do и создадут комнату заново.
+func (h *Hub) deleteRoomIfEmpty(room *Room) {
+	h.roomsMu.Lock()
+	defer h.roomsMu.Unlock()
+
+	room.do(func() {
+		if len(room.Clients) > 0 || len(room.Pending) > 0 || h.rooms.GetRoom(room.key) != room {
+			return
+		}
+
+		h.rooms.DeleteRoom(room.key)
+		room.stop()
+		activeRoomsGauge.Dec()
+		logger.Info("Комната удалена (пустая)", "room_id", room.key)
+		h.emitAdminEvent(Message{Type: "room-destroyed", RoomID: room.key})
+		h.queueWebhook("room-destroyed", room.key, 0)
+	})
+}
+
+// Принудительное закрытие комнаты: участники получают room-closed и отключаются,
+// ожидающие переподключения удаляются. key — ключ комнаты с префиксом арендатора. Возвращает число отключенных клиентов и false, если комнаты нет.
+func (h *Hub) closeRoom(key string) (int, bool) {
+	h.roomsMu.Lock()
+	room := h.rooms.GetRoom(key)
+	if room == nil {
+		h.roomsMu.Unlock()
+		return 0, false
+	}
+
+	closed := 0
+	room.do(func() {
+		closed = h.evictRoom(room, "")
+	})
+	h.roomsMu.Unlock()
+
+	logger.Info("Комната закрыта администратором", "room_id", key, "clients", closed)
+	h.emitAdminEvent(Message{Type: "room-destroyed", RoomID: key})
+	h.queueWebhook("room-destroyed", key, closed)
+	return closed, true
+}
+
+// Удаление комнаты с отключением участников, которые получают room-closed с причиной reason.
+// Вызывается в горутине комнаты под h.roomsMu. Возвращает число отключенных клиентов.
+func (h *Hub) evictRoom(room *Room, reason string) int {
+	h.rooms.DeleteRoom(room.key)
+	room.stop()
+	activeRoomsGauge.Dec()
+
+	for pendingID, pending := range room.Pending {
+		pending.timer.Stop()
+		delete(room.Pending, pendingID)
+	}
+
+	// writePump доставит room-closed и закроет соединение
+	closed := 0
+	for id, c := range room.Clients {
+		h.rooms.RemoveClient(room, id)
+		c.send(Message{Type: "room-closed", RoomID: room.ID, Reason: reason})
+		c.closeWith(websocket.CloseNormalClosure, "room closed")
+		closed++
+	}
+	room.HostID = ""
+	return closed
+}
+
+// Уведомление всех клиентов об остановке сервера и закрытие их соединений.
+// Ждет завершения обработчиков соединений, пока не истечет ctx.
+func (h *Hub) shutdownClients(ctx context.Context) {
+	h.clientsMu.Lock()
+	active := make([]*Client, 0, len(h.clients))
+	for client := range h.clients {
+		active = append(active, client)
+	}
+	h.clientsMu.Unlock()
+
+	logger.Info("Отключение клиентов", "clients", len(active))
+
+	// writePump отправит уведомление и закроет соединение после закрытия отправки
+	for _, client := range active {
+		client.send(Message{Type: "server-shutting-down"})
+		client.closeWith(websocket.CloseGoingAway, "server shutting down")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		h.clientsWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		logger.Warn("Таймаут отключения клиентов, соединения закрываются принудительно")
+		for _, client := range active {
+			client.Conn.Close()
+		}
+	}
+}
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Ожидание условия, которое сервер выполняет асинхронно
+func waitFor(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(fakeConnWait)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: not reached within %s", what, fakeConnWait)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Без grace периода разрыв соединения сразу удаляет клиента из комнаты
+func withoutReconnectGrace(t *testing.T) {
+	saved := reconnectGrace
+	reconnectGrace = 0
+	t.Cleanup(func() { reconnectGrace = saved })
+}
+
+// Вход в комнату и ожидание joined
+func joinFake(t testing.TB, h *Hub, roomID, from string) (*fakeConn, <-chan struct{}, Message) {
+	t.Helper()
+	conn, done := serveFake(t, h)
+	conn.write(t, Message{Type: "join", RoomID: roomID, From: from})
+	return conn, done, conn.expect(t, "joined")
+}
+
+func TestJoinNotifiesParticipants(t *testing.T) {
+	h := newHub()
+
+	alice, _, joined := joinFake(t, h, "join", "alice")
+	if joined.HostID != "alice" || len(joined.Participants) != 0 {
+		t.Fatalf("alice joined = %+v, want host alice and no participants", joined)
+	}
+
+	_, _, joined = joinFake(t, h, "join", "bob")
+	if joined.HostID != "alice" || len(joined.Participants) != 1 || joined.Participants[0].ID != "alice" {
+		t.Fatalf("bob joined = %+v, want host alice and participant alice", joined)
+	}
+
+	if msg := alice.expect(t, "user-joined"); msg.From != "bob" {
+		t.Fatalf("user-joined from %q, want bob", msg.From)
+	}
+}
+
+func TestRejoinSameRoomKeepsRoom(t *testing.T) {
+	withoutReconnectGrace(t)
+	h := newHub()
+
+	alice, _ := serveFake(t, h)
+	alice.write(t, Message{Type: "join", RoomID: "locked", From: "alice", Password: "secret"})
+	alice.expect(t, "joined")
+
+	// Единственный участник повторяет join: комната с паролем не должна пересоздаваться
+	alice.write(t, Message{Type: "join", RoomID: "locked", From: "alice"})
+	if msg := alice.expect(t, "error"); msg.Code != "already-in-room" {
+		t.Fatalf("error code %q, want already-in-room", msg.Code)
+	}
+
+	carol, _ := serveFake(t, h)
+	carol.write(t, Message{Type: "join", RoomID: "locked", From: "carol"})
+	carol.expect(t, "auth-failed")
+
+	bob, _ := serveFake(t, h)
+	bob.write(t, Message{Type: "join", RoomID: "locked", From: "bob", Password: "secret"})
+	bob.expect(t, "joined")
+	alice.expect(t, "user-joined")
+
+	// В общей комнате повторный join не рассылает user-left и не меняет ведущего
+	bob.write(t, Message{Type: "join", RoomID: "locked", From: "bob", Password: "secret"})
+	bob.expect(t, "error")
+	bob.write(t, Message{Type: "sync"})
+	if state := bob.expect(t, "room-state"); state.HostID != "alice" || len(state.Participants) != 2 {
+		t.Fatalf("room-state = %+v, want host alice and two participants", state)
+	}
+	alice.write(t, Message{Type: "leave"})
+	if msg := bob.expect(t, "user-left"); msg.From != "alice" {
+		t.Fatalf("first user-left from %q, want alice", msg.From)
+	}
+}
+
+func TestResumeReplacesLiveConnection(t *testing.T) {
+	h := newHub()
+	alice, aliceDone, joined := joinFake(t, h, "resume", "alice")
+	bob, _, _ := joinFake(t, h, "resume", "bob")
+	alice.expect(t, "user-joined")
+
+	// Старое соединение alice для сервера еще живо, а клиент уже переподключился по токену
+	alice2, _ := serveFake(t, h)
+	alice2.write(t, Message{Type: "resume", Token: joined.Token})
+	if msg := alice2.expect(t, "joined"); !msg.Resumed || msg.HostID != "alice" {
+		t.Fatalf("resumed joined = %+v, want resumed with host alice", msg)
+	}
+	waitServed(t, aliceDone)
+	if code := alice.sentCloseCode(); code != websocket.CloseNormalClosure {
+		t.Fatalf("old connection close code = %d, want %d", code, websocket.CloseNormalClosure)
+	}
+
+	// Для bob участник не менялся: ни user-left, ни user-joined, и сообщения идут новому соединению
+	bob.write(t, Message{Type: "offer", To: "alice", Data: []byte(`{"type":"offer","sdp":"v=0"}`)})
+	alice2.expect(t, "offer")
+	bob.write(t, Message{Type: "sync"})
+	for {
+		msg := bob.expect(t, "")
+		if msg.Type == "user-left" || msg.Type == "user-joined" {
+			t.Fatalf("bob got %s for the resumed participant", msg.Type)
+		}
+		if msg.Type == "room-state" {
+			if len(msg.Participants) != 2 {
+				t.Fatalf("room-state participants = %+v, want alice and bob", msg.Participants)
+			}
+			break
+		}
+	}
+}
+
+func TestRejoinPendingRequiresToken(t *testing.T) {
+	h := newHub()
+	alice, aliceDone, joined := joinFake(t, h, "grace", "alice")
+	bob, _, _ := joinFake(t, h, "grace", "bob")
+
+	// alice теряет соединение и ждет переподключения в течение RECONNECT_GRACE
+	alice.Close()
+	waitServed(t, aliceDone)
+
+	mallory, _ := serveFake(t, h)
+	mallory.write(t, Message{Type: "join", RoomID: "grace", From: "alice"})
+	if msg := mallory.expect(t, "join-rejected"); msg.Reason != "duplicate-id" {
+		t.Fatalf("join-rejected reason %q, want duplicate-id", msg.Reason)
+	}
+
+	alice2, _ := serveFake(t, h)
+	alice2.write(t, Message{Type: "join", RoomID: "grace", From: "alice", Username: "Alice", Token: joined.Token})
+	if msg := alice2.expect(t, "joined"); !msg.Resumed {
+		t.Fatalf("joined = %+v, want resumed", msg)
+	}
+	msg := bob.expect(t, "")
+	if msg.Type != "user-renamed" || msg.From != "alice" || msg.Username != "Alice" {
+		t.Fatalf("bob got %+v, want user-renamed for alice to Alice", msg)
+	}
+}
+
+func TestSignalingRelay(t *testing.T) {
+	tests := []struct {
+		msgType string
+		data    string
+	}{
+		{"offer", `{"type":"offer","sdp":"v=0"}`},
+		{"answer", `{"type":"answer","sdp":"v=0"}`},
+		{"ice-candidate", `{"candidate":"candidate:1 1 udp 1 127.0.0.1 9 typ host","sdpMid":"0"}`},
+	}
+
+	h := newHub()
+	alice, _, _ := joinFake(t, h, "relay", "alice")
+	bob, _, _ := joinFake(t, h, "relay", "bob")
+
+	for _, tt := range tests {
+		t.Run(tt.msgType, func(t *testing.T) {
+			alice.write(t, Message{Type: tt.msgType, To: "bob", Data: []byte(tt.data)})
+
+			msg := bob.expect(t, tt.msgType)
+			if msg.From != "alice" || string(msg.Data) != tt.data {
+				t.Fatalf("bob got %s from %q with data %s, want from alice with %s", msg.Type, msg.From, msg.Data, tt.data)
+			}
+		})
+	}
+
+	t.Run("unknown peer", func(t *testing.T) {
+		alice.write(t, Message{Type: "offer", To: "carol", Data: []byte(`{"sdp":"v=0"}`)})
+		if msg := alice.expect(t, "error"); msg.Code != "peer-not-found" {
+			t.Fatalf("error code %q, want peer-not-found", msg.Code)
+		}
+	})
+}
+
+func TestSeqContiguousPerRecipient(t *testing.T) {
+	h := newHub()
+	alice, _, _ := joinFake(t, h, "seq", "alice")
+	bob, _, _ := joinFake(t, h, "seq", "bob")
+	carol, _, _ := joinFake(t, h, "seq", "carol")
+	offer := []byte(`{"type":"offer","sdp":"v=0"}`)
+
+	// Сообщения alice вперемешку разным получателям; неизвестный получатель seq не расходует
+	alice.write(t, Message{Type: "offer", To: "bob", Data: offer})
+	alice.write(t, Message{Type: "offer", To: "carol", Data: offer})
+	alice.write(t, Message{Type: "offer", To: "dave", Data: offer})
+	alice.expect(t, "error")
+	// broadcast идет через основной буфер, как и signaling, поэтому порядок доставки известен
+	alice.write(t, Message{Type: "broadcast", Data: []byte(`"hi"`)})
+	alice.write(t, Message{Type: "answer", To: "bob", Data: offer})
+
+	for _, tt := range []struct {
+		conn  *fakeConn
+		types []string
+	}{
+		{bob, []string{"offer", "broadcast", "answer"}},
+		{carol, []string{"offer", "broadcast"}},
+	} {
+		for i, msgType := range tt.types {
+			if msg := tt.conn.expect(t, msgType); msg.Seq != int64(i+1) {
+				t.Fatalf("%s %d: seq = %d, want %d", msgType, i, msg.Seq, i+1)
+			}
+		}
+	}
+}
+
+func TestBroadcastDropsForgedFields(t *testing.T) {
+	h := newHub()
+	alice, _, _ := joinFake(t, h, "forged", "alice")
+	bob, _, _ := joinFake(t, h, "forged", "bob")
+
+	for _, msgType := range []string{"broadcast", "chat"} {
+		t.Run(msgType, func(t *testing.T) {
+			alice.write(t, Message{
+				Type:         msgType,
+				From:         "bob",
+				Username:     "mallory",
+				HostID:       "alice",
+				Participants: []Participant{{ID: "mallory"}},
+				Reason:       leaveReasonKick,
+				Code:         "kicked",
+				Seq:          100,
+				Data:         []byte(`"hi"`),
+			})
+
+			msg := bob.expect(t, msgType)
+			if msg.From != "alice" || string(msg.Data) != `"hi"` {
+				t.Fatalf("bob got %s from %q with data %s, want from alice with \"hi\"", msgType, msg.From, msg.Data)
+			}
+			if msg.Username == "mallory" || msg.HostID != "" || msg.Participants != nil || msg.Reason != "" || msg.Code != "" || msg.Seq == 100 {
+				t.Fatalf("bob got forged fields relayed: %+v", msg)
+			}
+		})
+	}
+}
+
+func TestLeaveCleansUpRoom(t *testing.T) {
+	withoutReconnectGrace(t)
+
+	tests := []struct {
+		name   string
+		leave  func(t *testing.T, conn *fakeConn)
+		reason string
+	}{
+		{
+			name:   "leave message",
+			leave:  func(t *testing.T, conn *fakeConn) { conn.write(t, Message{Type: "leave"}) },
+			reason: leaveReasonLeave,
+		},
+		{
+			name:   "disconnect",
+			leave:  func(t *testing.T, conn *fakeConn) { conn.Close() },
+			reason: leaveReasonDisconnect,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHub()
+			alice, _, _ := joinFake(t, h, "cleanup", "alice")
+			bob, _, _ := joinFake(t, h, "cleanup", "bob")
+
+			tt.leave(t, bob)
+			msg := alice.expect(t, "user-left")
+			if msg.From != "bob" || msg.Reason != tt.reason {
+				t.Fatalf("user-left = %+v, want from bob with reason %s", msg, tt.reason)
+			}
+			if h.room(roomKey("", "cleanup")) == nil {
+				t.Fatal("room deleted while alice is still in it")
+			}
+
+			tt.leave(t, alice)
+			waitFor(t, "room deleted once empty", func() bool { return h.room(roomKey("", "cleanup")) == nil })
+		})
+	}
+}
+
+func TestEmptyRoomStopsDurationTimers(t *testing.T) {
+	withoutReconnectGrace(t)
+	saved := roomMaxDuration
+	roomMaxDuration = time.Hour
+	t.Cleanup(func() { roomMaxDuration = saved })
+
+	h := newHub()
+	alice, _, _ := joinFake(t, h, "limited", "alice")
+	room := h.room(roomKey("", "limited"))
+	if room == nil {
+		t.Fatal("room not created")
+	}
+
+	alice.write(t, Message{Type: "leave"})
+	waitFor(t, "room deleted once empty", func() bool { return h.room(roomKey("", "limited")) == nil })
+
+	if len(room.durationTimers) != 2 {
+		t.Fatalf("%d duration timers, want warning and expiry", len(room.durationTimers))
+	}
+	for i, timer := range room.durationTimers {
+		// Stop возвращает false, если таймер уже остановлен
+		if timer.Stop() {
+			t.Fatalf("duration timer %d still pending after the room was deleted", i)
+		}
+	}
+}
+
+// Параллельные join, leave, разрывы и kick в одной комнате; рассчитан на запуск с -race
+func TestConcurrentJoinLeaveKick(t *testing.T) {
+	withoutReconnectGrace(t)
+
+	const participants = 30
+	h := newHub()
+	key := roomKey("", "busy")
+
+	host, _, _ := joinFake(t, h, "busy", "host")
+	// Уведомления ведущему никто не проверяет, но буфер не должен переполняться
+	go func() {
+		for {
+			select {
+			case <-host.out:
+			case <-host.closed:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := range participants {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// t.Fatal нельзя вызывать вне горутины теста, поэтому ошибки через t.Error
+			id := fmt.Sprintf("p%d", i)
+			conn, done := serveFake(t, h)
+			err := conn.send(Message{Type: "join", RoomID: "busy", From: id})
+			if err == nil {
+				_, err = conn.recv("joined")
+			}
+			if err == nil {
+				switch i % 3 {
+				case 0:
+					if err = host.send(Message{Type: "kick", To: id}); err == nil {
+						_, err = conn.recv("kicked")
+					}
+				case 1:
+					err = conn.send(Message{Type: "leave"})
+					conn.Close()
+				case 2:
+					conn.Close()
+				}
+			}
+			if err != nil {
+				t.Errorf("%s: %v", id, err)
+				return
+			}
+
+			select {
+			case <-done:
+			case <-time.After(fakeConnWait):
+				t.Errorf("%s: serveConn did not return within %s", id, fakeConnWait)
+			}
+		}()
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	h.roomsMu.Lock()
+	room := h.rooms.GetRoom(key)
+	h.roomsMu.Unlock()
+	if room == nil {
+		t.Fatal("room deleted while the host is still in it")
+	}
+	waitFor(t, "only the host left in the room", func() bool {
+		left := -1
+		room.do(func() { left = len(room.Clients) + len(room.Pending) })
+		return left == 1
+	})
+
+	host.write(t, Message{Type: "leave"})
+	waitFor(t, "room deleted once empty", func() bool { return h.room(key) == nil })
+}
+
+// Рассылки и signaling в одной комнате параллельно с обходами под roomsMu и закрытием комнаты.
+// Часть клиентов не читает свои сообщения, а relay ждет места в их буфере: закрытие
+// комнаты в ее горутине не должно дожидаться этих отправок. Рассчитан на запуск с -race
+func TestConcurrentRoomTraffic(t *testing.T) {
+	// Ожидание relay заведомо дольше теста: закрытие, которое его ждет, не уложится в срок
+	savedTimeout, savedBuffer := relaySendTimeout, sendBufferSize
+	relaySendTimeout, sendBufferSize = time.Minute, 4
+	t.Cleanup(func() { relaySendTimeout, sendBufferSize = savedTimeout, savedBuffer })
+
+	const (
+		participants = 8
+		messages     = 1200
+	)
+	h := newHub()
+	key := roomKey("", "traffic")
+
+	conns := make([]*fakeConn, participants)
+	for i := range conns {
+		conns[i], _, _ = joinFake(t, h, "traffic", fmt.Sprintf("p%d", i))
+		// Четные клиенты читают все, нечетные — медленные потребители
+		if i%2 == 0 {
+			go func(conn *fakeConn) {
+				for {
+					select {
+					case <-conn.out:
+					case <-conn.closed:
+						return
+					}
+				}
+			}(conns[i])
+		}
+	}
+
+	withAdminToken(t, "secret")
+	adminRequest := func(path string) *http.Request {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		return req
+	}
+
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			h.handleRooms(httptest.NewRecorder(), adminRequest("/rooms"))
+			h.handleStats(httptest.NewRecorder(), adminRequest("/stats"))
+			h.reapIdleRooms(time.Now())
+		}
+	}()
+
+	var senders sync.WaitGroup
+	for i, conn := range conns {
+		senders.Add(1)
+		go func() {
+			defer senders.Done()
+			peer := fmt.Sprintf("p%d", (i+1)%participants)
+			typing := true
+			for n := range messages {
+				var msg Message
+				switch n % 3 {
+				case 0:
+					msg = Message{Type: "chat", Data: []byte(`"hello"`)}
+				case 1:
+					msg = Message{Type: "typing", Typing: &typing}
+				case 2:
+					msg = Message{Type: "offer", To: peer, Data: []byte(`{"type":"offer","sdp":"v=0"}`)}
+				}
+				// После закрытия комнаты соединение закрыто сервером
+				if conn.send(msg) != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	// Комнату закрывают, когда буферы медленных клиентов уже заполнены
+	time.Sleep(200 * time.Millisecond)
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		h.closeRoom(key)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(fakeConnWait):
+		t.Fatalf("closeRoom did not return within %s", fakeConnWait)
+	}
+
+	senders.Wait()
+	close(stop)
+	readers.Wait()
+	waitFor(t, "room deleted after close", func() bool { return h.room(key) == nil })
+}
+
+// Получатель выходит из комнаты, пока ему пересылаются signaling сообщения, а его буфер
+// переполнен: relay идет вне горутины комнаты и не должен читать ID и RoomID получателя,
+// которые меняет его read loop. Рассчитан на запуск с -race
+func TestLeaveDuringRelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+	}{
+		{"without send timeout", 0},
+		{"with send timeout", time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			savedTimeout, savedBuffer := relaySendTimeout, sendBufferSize
+			relaySendTimeout, sendBufferSize = tt.timeout, 1
+			t.Cleanup(func() { relaySendTimeout, sendBufferSize = savedTimeout, savedBuffer })
+
+			h := newHub()
+			alice, _, _ := joinFake(t, h, "relay-leave", "alice")
+			// bob не читает свои сообщения, поэтому его буфер быстро переполняется
+			bob, _, _ := joinFake(t, h, "relay-leave", "bob")
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 1000 {
+					if alice.send(Message{Type: "offer", To: "bob", Data: []byte(`{"type":"offer","sdp":"v=0"}`)}) != nil {
+						return
+					}
+				}
+			}()
+
+			// Выход, когда буфер bob уже заполнен и пересылки отбрасываются
+			time.Sleep(100 * time.Millisecond)
+			bob.write(t, Message{Type: "leave"})
+			wg.Wait()
+			waitFor(t, "bob removed from the room", func() bool {
+				room := h.room(roomKey("", "relay-leave"))
+				count := 0
+				room.do(func() { count = len(room.Clients) })
+				return count == 1
+			})
+		})
+	}
+}
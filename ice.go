@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Описание ICE сервера в формате RTCIceServer
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// Временные учетные данные TURN по схеме coturn (use-auth-secret):
+// username = "<unix-время истечения>:<имя>", credential = base64(HMAC-SHA1(secret, username))
+func turnCredentials(secret, name string, ttl time.Duration) (username, credential string) {
+	expiresAt := time.Now().Add(ttl).Unix()
+	username = strconv.FormatInt(expiresAt, 10) + ":" + name
+
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	credential = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return username, credential
+}
+
+// STUN серверы из STUN_URLS в формате RTCIceServer; учетные данные для них не нужны
+func stunServers() []ICEServer {
+	servers := []ICEServer{}
+	if len(stunURLs) > 0 {
+		servers = append(servers, ICEServer{URLs: stunURLs})
+	}
+	return servers
+}
+
+// Обработчик выдачи списка ICE серверов
+func handleICEServers(w http.ResponseWriter, r *http.Request) {
+	name := "instantmeet"
+	if jwtSecret != "" {
+		userID, _, err := authenticate(r)
+		if err != nil {
+			logger.Warn("Запрос ICE серверов с невалидным токеном", "error", err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		name = userID
+	}
+
+	servers := stunServers()
+	if len(turnURLs) > 0 && turnSecret != "" {
+		username, credential := turnCredentials(turnSecret, name, turnTTL)
+		servers = append(servers, ICEServer{
+			URLs:       turnURLs,
+			Username:   username,
+			Credential: credential,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"iceServers":  servers,
+		"ttl_seconds": int64(turnTTL.Seconds()),
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import "time"
+
+// Наблюдение за активностью в комнате (ROOM_INACTIVITY_TIMEOUT): если участники подключены,
+// но дольше таймаута ничего не пересылают, все получают inactivity-timeout, а если активность
+// не возобновится за ROOM_INACTIVITY_GRACE, комната закрывается.
+// В отличие от janitor, закрывает «зомби» встречи с подключенными клиентами.
+func (h *Hub) watchInactivity(room *Room) {
+	if roomInactivityTimeout <= 0 {
+		return
+	}
+	time.AfterFunc(roomInactivityTimeout, func() { h.checkInactivity(room) })
+}
+
+// Проверка активности комнаты и планирование следующей проверки
+func (h *Hub) checkInactivity(room *Room) {
+	h.roomsMu.Lock()
+	closed := -1
+	var next time.Duration
+	// Удаленная комната не отвечает на do, и проверки прекращаются
+	room.do(func() {
+		now := time.Now()
+		idle := now.Sub(room.lastActivity)
+
+		// Активность была недавно (в том числе после предупреждения) или в комнате никого нет:
+		// пустыми комнатами занимается janitor
+		if idle < roomInactivityTimeout || len(room.Clients) == 0 {
+			room.inactivityWarned = time.Time{}
+			next = max(roomInactivityTimeout-idle, time.Second)
+			return
+		}
+
+		if room.inactivityWarned.IsZero() {
+			room.inactivityWarned = now
+			next = roomInactivityGrace
+			for _, c := range room.Clients {
+				c.send(Message{
+					Type:   "inactivity-timeout",
+					RoomID: room.ID,
+					Reason: "room will be closed due to inactivity",
+				})
+			}
+			logger.Info("Нет активности в комнате, участники предупреждены", "room_id", room.key, "idle", idle.String(), "grace", roomInactivityGrace.String())
+			return
+		}
+
+		if remaining := roomInactivityGrace - now.Sub(room.inactivityWarned); remaining > 0 {
+			next = remaining
+			return
+		}
+		closed = h.evictRoom(room, "inactivity")
+	})
+	h.roomsMu.Unlock()
+
+	if closed < 0 {
+		if next > 0 {
+			time.AfterFunc(next, func() { h.checkInactivity(room) })
+		}
+		return
+	}
+
+	logger.Info("Комната закрыта из-за отсутствия активности", "room_id", room.key, "clients", closed)
+	h.emitAdminEvent(Message{Type: "room-destroyed", RoomID: room.key})
+	h.queueWebhook("room-destroyed", room.key, closed)
+}
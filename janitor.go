@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Интервал проверки комнат фоновым janitor
+const reapInterval = 30 * time.Second
+
+// Фоновая очистка комнат, в которых дольше roomIdleTTL нет подключенных клиентов
+// (например, остались только клиенты, ожидающие переподключения)
+func (h *Hub) runJanitor(ctx context.Context) {
+	if roomIdleTTL <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(min(reapInterval, roomIdleTTL))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			h.reapIdleRooms(now)
+		}
+	}
+}
+
+// Один проход очистки. h.roomsMu держится на весь проход, комнаты проверяются в своих горутинах.
+func (h *Hub) reapIdleRooms(now time.Time) {
+	h.roomsMu.Lock()
+	defer h.roomsMu.Unlock()
+
+	for _, room := range h.rooms.Rooms() {
+		id := room.key
+		room.do(func() {
+			if len(room.Clients) > 0 {
+				room.emptySince = time.Time{}
+				return
+			}
+			if room.emptySince.IsZero() {
+				room.emptySince = now
+			}
+			if now.Sub(room.emptySince) < roomIdleTTL {
+				return
+			}
+
+			for pendingID, pending := range room.Pending {
+				pending.timer.Stop()
+				delete(room.Pending, pendingID)
+			}
+
+			h.rooms.DeleteRoom(id)
+			room.stop()
+			activeRoomsGauge.Dec()
+			logger.Info("Комната удалена janitor (нет подключенных клиентов)", "room_id", id, "idle", now.Sub(room.emptySince).String())
+			h.emitAdminEvent(Message{Type: "room-destroyed", RoomID: id})
+			h.queueWebhook("room-destroyed", id, 0)
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Структурированный JSON логгер; уровень задается LOG_LEVEL (debug, info, warn, error)
+var logger = newLogger(os.Getenv("LOG_LEVEL"))
+
+// Создание логгера с JSON выводом в stdout
+func newLogger(level string) *slog.Logger {
+	var lvl slog.Level
+	switch strings.ToLower(level) {
+	case "debug":
+		lvl = slog.LevelDebug
+	case "warn", "warning":
+		lvl = slog.LevelWarn
+	case "error":
+		lvl = slog.LevelError
+	default:
+		lvl = slog.LevelInfo
+	}
+
+	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: lvl}))
+}
+
+// Запись payload пересылаемого сообщения в debug лог, если включен LOG_PAYLOADS.
+// Payload обрезается до logPayloadLimit байт.
+func logPayload(roomID string, msg Message) {
+	if !logPayloads || (msg.Data == nil && msg.Binary == nil) {
+		return
+	}
+
+	attrs := []any{"client_id", msg.From, "room_id", roomID, "msg_type", msg.Type, "to", msg.To}
+	if msg.Binary != nil {
+		attrs = append(attrs, "binary_bytes", len(msg.Binary))
+	} else {
+		attrs = append(attrs, "payload", truncatePayload(string(msg.Data), logPayloadLimit), "payload_bytes", len(msg.Data))
+	}
+	logger.Debug("Payload сообщения", attrs...)
+}
+
+// Обрезка строки до limit байт по границе UTF-8 символа; limit <= 0 — без обрезки
+func truncatePayload(s string, limit int) string {
+	if limit <= 0 || len(s) <= limit {
+		return s
+	}
+	for limit > 0 && !utf8.RuneStart(s[limit]) {
+		limit--
+	}
+	return s[:limit] + "…"
+}
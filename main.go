@@ -1,285 +1,611 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"net"
 	"net/http"
-	"sync"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// Структура для клиента
-type Client struct {
-	ID       string
-	Conn     *websocket.Conn
-	RoomID   string
-	Username string
-	Send     chan Message
-	mu       sync.Mutex
-}
+// Параметры heartbeat и таймаутов соединения
+const (
+	// Максимальное время на запись одного сообщения; медленный клиент отключается
+	writeWait = 10 * time.Second
+	// Время на уведомление и отключение клиентов при остановке сервера
+	shutdownTimeout = 10 * time.Second
+	// Время ожидания pong от клиента, после которого соединение считается мертвым
+	pongWait = 60 * time.Second
+	// Интервал отправки ping (должен быть меньше pongWait)
+	pingPeriod = 30 * time.Second
+)
 
-// Структура для комнаты
-type Room struct {
-	ID      string
-	Clients map[*Client]bool
-	mu      sync.Mutex
-}
+// Поддерживаемые версии протокола сообщений; join без protocolVersion считается совместимым
+const (
+	minProtocolVersion = 1
+	maxProtocolVersion = 1
+)
 
 // Структура сообщения
 type Message struct {
-	Type     string          `json:"type"`
-	From     string          `json:"from,omitempty"`
-	To       string          `json:"to,omitempty"`
-	RoomID   string          `json:"roomId,omitempty"`
-	Username string          `json:"username,omitempty"`
-	Data     json.RawMessage `json:"data,omitempty"`
+	Type         string          `json:"type"`
+	ID           string          `json:"id,omitempty"` // ID клиента на сервере (в whoami-result)
+	From         string          `json:"from,omitempty"`
+	To           string          `json:"to,omitempty"`
+	ToList       []string        `json:"toList,omitempty"` // Несколько получателей вместо To
+	RoomID       string          `json:"roomId,omitempty"`
+	Username     string          `json:"username,omitempty"`
+	Code         string          `json:"code,omitempty"`
+	Reason       string          `json:"reason,omitempty"`
+	Limit        int             `json:"limit,omitempty"`
+	AssignedID   string          `json:"assignedId,omitempty"`   // ID, выданный сервером, если в join нет from
+	Participants []Participant   `json:"participants,omitempty"` // Уже присутствующие участники (в joined)
+	Resumed      bool            `json:"resumed,omitempty"`      // Клиент восстановлен после переподключения
+	HostID       string          `json:"hostId,omitempty"`       // Текущий ведущий комнаты
+	Password     string          `json:"password,omitempty"`     // Пароль комнаты в join (никогда не пересылается)
+	Seq          int64           `json:"seq,omitempty"`          // Номер сообщения от отправителя этому получателю, без пропусков
+	Typing       *bool           `json:"typing,omitempty"`       // Индикатор набора текста (в typing)
+	Metadata     json.RawMessage `json:"metadata,omitempty"`     // Метаданные комнаты от создателя (в join и joined)
+	Token        string          `json:"token,omitempty"`        // Токен переподключения (в joined и resume)
+	Echo         bool            `json:"echo,omitempty"`         // Вернуть рассылаемое сообщение и отправителю
+	ICEServers   []ICEServer     `json:"iceServers,omitempty"`   // STUN серверы по умолчанию (в joined)
+	Hands        []string        `json:"hands,omitempty"`        // Очередь поднятых рук (в hands-updated, joined и room-state)
+	// Заголовки подключения из RELAY_HEADERS (в user-joined)
+	Headers map[string]string `json:"headers,omitempty"`
+	// Время принудительного закрытия комнаты по ROOM_MAX_DURATION (в joined и time-limit-warning)
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// Версия протокола клиента (в join); в joined и отказе — поддерживаемый сервером диапазон
+	ProtocolVersion    int             `json:"protocolVersion,omitempty"`
+	MinProtocolVersion int             `json:"minProtocolVersion,omitempty"`
+	MaxProtocolVersion int             `json:"maxProtocolVersion,omitempty"`
+	Data               json.RawMessage `json:"data,omitempty"`
+	Binary             []byte          `json:"-"` // Готовый бинарный фрейм; writePump отправит его как BinaryMessage
+}
+
+// Краткая информация о комнате для /rooms
+type RoomInfo struct {
+	RoomID           string          `json:"roomId"`
+	ParticipantCount int             `json:"participantCount"`
+	Usernames        []string        `json:"usernames"`
+	Metadata         json.RawMessage `json:"metadata,omitempty"`
+}
+
+// Подробная информация о комнате для /rooms/{id}
+type RoomDetails struct {
+	RoomID           string          `json:"roomId"`
+	HostID           string          `json:"hostId,omitempty"`
+	CreatedAt        time.Time       `json:"createdAt"`
+	ParticipantCount int             `json:"participantCount"`
+	Participants     []Participant   `json:"participants"`
+	Pending          int             `json:"pending"`
+	Metadata         json.RawMessage `json:"metadata,omitempty"`
 }
 
-// Глобальные переменные
-var (
-	upgrader = websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool {
-			return true // Разрешаем все origins для разработки
-		},
+// Проверка Host по списку ALLOWED_HOSTS (защита от DNS rebinding) и Origin по списку ALLOWED_ORIGINS.
+// Без настроенного списка соответствующая проверка пропускается.
+func checkOrigin(r *http.Request) bool {
+	if !checkHost(r.Host) {
+		logger.Warn("Отклонено соединение с недопустимым Host", "host", r.Host)
+		return false
 	}
-	rooms   = make(map[string]*Room)
-	roomsMu sync.Mutex
-)
 
-// Writer goroutine для клиента
-func (c *Client) writePump() {
-	defer func() {
-		c.Conn.Close()
-	}()
+	if len(allowedOrigins) == 0 {
+		return true
+	}
 
-	for message := range c.Send {
-		data, err := json.Marshal(message)
-		if err != nil {
-			log.Printf("Ошибка сериализации сообщения: %v", err)
-			continue
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// Не браузерные клиенты не присылают Origin
+		return true
+	}
+
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
 		}
+	}
 
-		if err := c.Conn.WriteMessage(websocket.TextMessage, data); err != nil {
-			log.Printf("Ошибка отправки сообщения клиенту %s: %v", c.ID, err)
-			return
+	logger.Warn("Отклонено соединение с недопустимым Origin", "origin", origin)
+	return false
+}
+
+// Проверка заголовка Host; элемент списка совпадает с host:port целиком или с именем хоста без порта
+func checkHost(host string) bool {
+	if len(allowedHosts) == 0 {
+		return true
+	}
+
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+
+	for _, allowed := range allowedHosts {
+		if strings.EqualFold(allowed, host) || strings.EqualFold(allowed, hostname) {
+			return true
 		}
 	}
+	return false
 }
 
 // Обработчик WebSocket соединений
-func handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+func (h *Hub) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	// Обычный HTTP запрос (например, из браузера) получает понятный ответ вместо пустого
+	if !websocket.IsWebSocketUpgrade(r) {
+		w.Header().Set("Upgrade", "websocket")
+		http.Error(w, "Upgrade Required: /ws accepts only WebSocket connections (ws:// or wss://)", http.StatusUpgradeRequired)
+		return
+	}
+
+	// Проверка JWT до upgrade, если настроен секрет
+	var authID, authUsername string
+	if jwtSecret != "" {
+		var err error
+		authID, authUsername, err = authenticate(r)
+		if err != nil {
+			logger.Warn("Отклонено соединение с невалидным токеном", "error", err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	ip := clientIP(r)
+	if !h.connLimiter.acquire(ip) {
+		logger.Warn("Превышен лимит соединений с IP", "ip", ip)
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		return
+	}
+	defer h.connLimiter.release(ip)
+
+	// Место резервируется до upgrade, чтобы параллельные подключения не превысили лимит
+	if n := h.activeClients.Add(1); maxClients > 0 && n > int64(maxClients) {
+		h.activeClients.Add(-1)
+		logger.Warn("Достигнут лимит клиентов на сервере", "limit", maxClients)
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	defer h.activeClients.Add(-1)
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("Ошибка upgrade соединения: %v", err)
+		logger.Warn("Ошибка upgrade соединения", "error", err)
 		return
 	}
 
-	client := &Client{
-		Conn: conn,
-		Send: make(chan Message, 256),
+	client := newClient(conn)
+	if cc := countingConnFrom(r.Context()); cc != nil {
+		cc.track(&client.traffic)
+	}
+	client.AuthID = authID
+	client.AuthUsername = authUsername
+	client.IP = ip
+	client.UserAgent = truncatePayload(r.UserAgent(), maxUserAgentLength)
+	client.Headers = relayHeadersFrom(r)
+	client.Tenant = tenantFromRequest(r)
+	client.URLRoomID = r.PathValue("roomId")
+	if client.URLRoomID == "" {
+		client.URLRoomID = r.URL.Query().Get("roomId")
 	}
 
+	h.serveConn(client)
+}
+
+// Обслуживание соединения клиента до его закрытия: настройка, writePump и read loop.
+// Работает с любым Conn, поэтому не зависит от сетевого сокета.
+func (h *Hub) serveConn(client *Client) {
+	conn := client.Conn
+
+	// Слишком большие сообщения приводят к ошибке чтения и отключению клиента
+	conn.SetReadLimit(maxMessageSize)
+
+	// permessage-deflate применяется, только если клиент согласовал расширение
+	conn.EnableWriteCompression(enableCompression)
+
+	// До первого успешного join действует дедлайн joinTimeout, и pong его не продлевает:
+	// соединения, так и не вошедшие в комнату, закрываются.
+	// После join каждый pong продлевает дедлайн чтения; без pong ReadMessage вернет ошибку.
+	joined := joinTimeout <= 0
+	if joined {
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+	} else {
+		conn.SetReadDeadline(time.Now().Add(joinTimeout))
+	}
+	conn.SetPongHandler(func(string) error {
+		if !joined {
+			return nil
+		}
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	connectedClientsGauge.Inc()
+	defer connectedClientsGauge.Dec()
+
+	h.clientsWG.Add(1)
+	defer h.clientsWG.Done()
+	h.clientsMu.Lock()
+	h.clients[client] = true
+	h.clientsMu.Unlock()
+	defer func() {
+		h.clientsMu.Lock()
+		delete(h.clients, client)
+		h.clientsMu.Unlock()
+	}()
+
 	// Запускаем горутину для отправки сообщений
 	go client.writePump()
 
-	log.Printf("Новое WebSocket соединение")
+	logger.Info("Новое WebSocket соединение", "client_id", client.ID, client.connAttr())
 
 	// Чтение сообщений от клиента
 	for {
-		_, messageData, err := conn.ReadMessage()
+		messageType, messageData, err := conn.ReadMessage()
 		if err != nil {
-			log.Printf("Ошибка чтения сообщения: %v", err)
-			// Удаляем клиента из комнаты при разрыве соединения
-			if client.RoomID != "" {
-				removeClientFromRoom(client)
+			logger.Info("Соединение закрыто", "client_id", client.ID, "room_id", client.RoomID, "error", err)
+			// Соединение, не вошедшее в комнату за joinTimeout, узнает причину из close фрейма
+			var netErr net.Error
+			if !joined && errors.As(err, &netErr) && netErr.Timeout() {
+				client.writeClose(websocket.ClosePolicyViolation, "join timeout")
 			}
-			close(client.Send)
+			h.teardown(client)
 			conn.Close()
+			if dropped := client.droppedCount(); dropped > 0 {
+				logger.Warn("Клиенту не доставлены сообщения", "client_id", client.ID, "dropped_total", dropped)
+			}
 			break
 		}
+		client.countPayloadIn(len(messageData))
 
-		var msg Message
-		if err := json.Unmarshal(messageData, &msg); err != nil {
-			log.Printf("Ошибка парсинга JSON: %v", err)
-			continue
-		}
+		h.handleMessage(client, messageType, messageData)
 
-		log.Printf("Получено сообщение типа: %s от клиента: %s", msg.Type, msg.From)
-
-		// Обработка различных типов сообщений
-		switch msg.Type {
-		case "join":
-			handleJoin(client, msg)
-		case "offer":
-			handleSignaling(client, msg)
-		case "answer":
-			handleSignaling(client, msg)
-		case "ice-candidate":
-			handleSignaling(client, msg)
-		case "leave":
-			handleLeave(client, msg)
-		default:
-			log.Printf("Неизвестный тип сообщения: %s", msg.Type)
+		// После входа в комнату (join или resume) дедлайн продлевается pong
+		if !joined && client.RoomID != "" {
+			joined = true
+			conn.SetReadDeadline(time.Now().Add(pongWait))
 		}
 	}
 }
 
-// Обработка присоединения к комнате
-func handleJoin(client *Client, msg Message) {
-	client.ID = msg.From
-	client.Username = msg.Username
-
-	// Если roomId пустой, игнорируем это сообщение
-	if msg.RoomID == "" {
-		log.Printf("Получен join без roomId от клиента %s", client.ID)
+// Обработка входящего сообщения клиента независимо от транспорта
+func (h *Hub) handleMessage(client *Client, messageType int, messageData []byte) {
+	// Сообщения сверх лимита отбрасываются; уведомление отправляется один раз на серию
+	if !client.allowMessage(time.Now()) {
+		// Сообщение не разбирается, поэтому его тип неизвестен
+		dropped := Message{Type: "unknown", From: client.ID}
+		if messageType == websocket.BinaryMessage {
+			dropped.Type = "binary"
+		}
+		if !client.rateLimited {
+			client.rateLimited = true
+			deadLetter(deadLetterRateLimited, dropped, "", client.RoomID)
+			client.send(Message{
+				Type:   "rate-limited",
+				Reason: "too many messages, some were dropped",
+			})
+		} else {
+			countDeadLetter(deadLetterRateLimited, dropped.Type)
+		}
 		return
 	}
+	client.rateLimited = false
 
-	client.RoomID = msg.RoomID
-
-	// Получаем или создаем комнату
-	roomsMu.Lock()
-	room, exists := rooms[msg.RoomID]
-	if !exists {
-		room = &Room{
-			ID:      msg.RoomID,
-			Clients: make(map[*Client]bool),
+	if messageType == websocket.BinaryMessage {
+		if !h.checkMessageType(client, "binary") {
+			return
 		}
-		rooms[msg.RoomID] = room
-		log.Printf("Создана новая комната: %s", msg.RoomID)
-	}
-	roomsMu.Unlock()
-
-	// Добавляем клиента в комнату
-	room.mu.Lock()
-	room.Clients[client] = true
-
-	// Уведомляем ВСЕХ других участников о новом пользователе
-	for otherClient := range room.Clients {
-		if otherClient != client {
-			notification := Message{
-				Type:     "user-joined",
-				From:     client.ID,
-				Username: client.Username,
-				RoomID:   msg.RoomID,
-			}
-			otherClient.Send <- notification
-			log.Printf("Отправлено уведомление user-joined клиенту %s о присоединении %s", otherClient.ID, client.ID)
+		client.countMessage()
+		messagesReceivedTotal.WithLabelValues("binary").Inc()
+		h.handleBinary(client, messageData)
+		return
+	}
+
+	var msg Message
+	if err := json.Unmarshal(messageData, &msg); err != nil {
+		client.badJSON++
+		logger.Warn("Ошибка парсинга JSON", "client_id", client.ID, "error", err, "consecutive", client.badJSON)
+		client.sendError("bad-json", err.Error())
+		// Поток мусора вместо сообщений: отключаем клиента
+		if maxBadJSON > 0 && client.badJSON >= maxBadJSON {
+			logger.Warn("Клиент отключен после серии невалидных JSON сообщений", "client_id", client.ID, "room_id", client.RoomID, "limit", maxBadJSON)
+			client.closeWith(websocket.CloseUnsupportedData, "too many malformed messages")
 		}
+		return
 	}
-	room.mu.Unlock()
+	client.badJSON = 0
+	// Заголовки участника задает только сервер: присланные клиентом не пересылаются ни в одном сообщении
+	msg.Headers = nil
 
-	log.Printf("Клиент %s (%s) присоединился к комнате %s", client.ID, client.Username, client.RoomID)
+	logger.Debug("Получено сообщение", "client_id", client.ID, "room_id", client.RoomID, "msg_type", msg.Type)
+	client.countMessage()
+	messagesReceivedTotal.WithLabelValues(metricsMessageType(msg.Type)).Inc()
 
-	// Отправляем подтверждение клиенту
-	response := Message{
-		Type:   "joined",
-		RoomID: client.RoomID,
+	if !h.checkMessageType(client, msg.Type) {
+		return
 	}
-	client.Send <- response
-}
 
-// Обработка signaling сообщений (offer, answer, ice-candidate)
-func handleSignaling(client *Client, msg Message) {
-	if msg.To == "" {
-		log.Printf("Сообщение без получателя")
-		return
+	// Обработка различных типов сообщений
+	switch msg.Type {
+	case "join":
+		h.Join(client, msg)
+	case "resume":
+		h.Resume(client, msg)
+	case "offer":
+		h.Signal(client, msg)
+	case "answer":
+		h.Signal(client, msg)
+	case "ice-candidate":
+		h.Signal(client, msg)
+	case "request-offer":
+		h.Signal(client, msg)
+	case "chat":
+		h.handleChat(client, msg)
+	case "broadcast":
+		h.handleBroadcast(client, msg)
+	case "typing":
+		h.handleTyping(client, msg)
+	case "media-state":
+		h.handleMediaState(client, msg)
+	case "kick":
+		h.handleKick(client, msg)
+	case "leave":
+		h.handleLeave(client, msg)
+	case "rename":
+		h.handleRename(client, msg)
+	case "sync":
+		h.handleSync(client, msg)
+	case "raise-hand":
+		h.handleRaiseHand(client, msg)
+	case "lower-hand":
+		h.handleLowerHand(client, msg)
+	case "whoami":
+		client.send(Message{
+			Type:     "whoami-result",
+			ID:       client.ID,
+			RoomID:   client.RoomID,
+			Username: client.Username,
+		})
+	case "ping":
+		// Keepalive на уровне приложения для клиентов за прокси, режущими control frames.
+		// Data возвращается как есть, чтобы клиент мог измерить RTT.
+		client.send(Message{Type: "pong", Data: msg.Data})
+	default:
+		logger.Warn("Неизвестный тип сообщения", "client_id", client.ID, "msg_type", msg.Type)
 	}
+}
 
-	roomsMu.Lock()
-	room := rooms[client.RoomID]
-	roomsMu.Unlock()
+// Обработчик для корневого пути
+func handleHome(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, "InstantMeet Signaling Server is running!")
+}
 
-	if room == nil {
-		log.Printf("Комната не найдена: %s", client.RoomID)
-		return
+// Обработчик health check для балансировщика
+func (h *Hub) handleHealth(w http.ResponseWriter, r *http.Request) {
+	roomList := h.roomList()
+	roomCount := len(roomList)
+
+	clientCount := 0
+	for _, room := range roomList {
+		room.do(func() {
+			clientCount += len(room.Clients)
+		})
 	}
 
-	room.mu.Lock()
-	defer room.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":         "ok",
+		"rooms":          roomCount,
+		"clients":        clientCount,
+		"uptime_seconds": int64(time.Since(h.startTime).Seconds()),
+	})
+}
 
-	// Ищем получателя в комнате
-	var targetClient *Client
-	for c := range room.Clients {
-		if c.ID == msg.To {
-			targetClient = c
-			break
-		}
-	}
+// Liveness probe: процесс жив, пока отвечает
+func handleLivez(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, "ok")
+}
 
-	if targetClient == nil {
-		log.Printf("Получатель не найден: %s", msg.To)
+// Readiness probe: 200, пока сервер принимает соединения и не останавливается.
+// При завершении работы отвечает 503, чтобы балансировщик перестал направлять новых клиентов.
+func (h *Hub) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !h.ready.Load() || h.shuttingDown.Load() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
 		return
 	}
+	fmt.Fprint(w, "ok")
+}
 
-	// Добавляем информацию об отправителе
-	msg.From = client.ID
+// Обработчик списка активных комнат. Список охватывает всех арендаторов и комнаты с паролем,
+// поэтому доступен только с ADMIN_TOKEN
+func (h *Hub) handleRooms(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
-	log.Printf("Перенаправление сообщения типа %s от %s к %s", msg.Type, msg.From, msg.To)
-	targetClient.Send <- msg
-}
+	roomList := h.roomList()
+
+	infos := make([]RoomInfo, 0, len(roomList))
+	for _, room := range roomList {
+		var info RoomInfo
+		if room.do(func() {
+			info = RoomInfo{
+				RoomID:           room.key,
+				ParticipantCount: len(room.Clients),
+				Usernames:        make([]string, 0, len(room.Clients)),
+				Metadata:         room.metadata,
+			}
+			for _, client := range room.Clients {
+				info.Usernames = append(info.Usernames, client.Username)
+			}
+		}) {
+			infos = append(infos, info)
+		}
+	}
 
-// Обработка выхода из комнаты
-func handleLeave(client *Client, msg Message) {
-	removeClientFromRoom(client)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(infos)
 }
 
-// Удаление клиента из комнаты
-func removeClientFromRoom(client *Client) {
-	if client.RoomID == "" {
+// Обработчик информации об одной комнате; снимок берется целиком в горутине комнаты.
+// Как и список комнат, доступен только с ADMIN_TOKEN
+func (h *Hub) handleRoom(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
 
-	roomsMu.Lock()
-	room := rooms[client.RoomID]
-	roomsMu.Unlock()
-
+	room := h.room(r.PathValue("id"))
 	if room == nil {
+		http.Error(w, "Room not found", http.StatusNotFound)
 		return
 	}
 
-	room.mu.Lock()
-	delete(room.Clients, client)
-	clientCount := len(room.Clients)
-
-	// Уведомляем других участников
-	for otherClient := range room.Clients {
-		notification := Message{
-			Type:   "user-left",
-			From:   client.ID,
-			RoomID: client.RoomID,
+	var details RoomDetails
+	if !room.do(func() {
+		details = RoomDetails{
+			RoomID:           room.key,
+			HostID:           room.HostID,
+			CreatedAt:        room.CreatedAt,
+			ParticipantCount: len(room.Clients),
+			Participants:     make([]Participant, 0, len(room.Clients)),
+			Pending:          len(room.Pending),
+			Metadata:         room.metadata,
+		}
+		// Заголовки из RELAY_HEADERS не отдаются: они предназначены только участникам
+		for _, client := range room.Clients {
+			details.Participants = append(details.Participants, Participant{ID: client.ID, Username: client.Username, Media: client.mediaState()})
 		}
-		otherClient.Send <- notification
+	}) {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
 	}
-	room.mu.Unlock()
 
-	log.Printf("Клиент %s покинул комнату %s", client.ID, client.RoomID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(details)
+}
 
-	// Удаляем пустую комнату
-	if clientCount == 0 {
-		roomsMu.Lock()
-		delete(rooms, client.RoomID)
-		roomsMu.Unlock()
-		log.Printf("Комната %s удалена (пустая)", client.RoomID)
+// Адрес для прослушивания из HOST и PORT; по умолчанию :3000 на всех интерфейсах
+func listenAddr(host, port string) (string, error) {
+	if port == "" {
+		port = "3000"
 	}
-}
 
-// Обработчик для корневого пути
-func handleHome(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintf(w, "InstantMeet Signaling Server is running!")
+	n, err := strconv.Atoi(port)
+	if err != nil || n <= 0 || n > 65535 {
+		return "", fmt.Errorf("PORT должен быть числом от 1 до 65535, получено %q", port)
+	}
+	return net.JoinHostPort(host, port), nil
 }
 
 func main() {
+	hub := newHub()
+	logger.Info("Запуск InstantMeet Signaling", "version", version, "commit", commit, "build_time", buildTime)
+
 	// Роуты
 	http.HandleFunc("/", handleHome)
-	http.HandleFunc("/ws", handleWebSocket)
+	http.HandleFunc("/health", hub.handleHealth)
+	http.HandleFunc("GET /livez", handleLivez)
+	http.HandleFunc("GET /readyz", hub.handleReadyz)
+	http.HandleFunc("GET /version", handleVersion)
+	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("GET /rooms", hub.handleRooms)
+	http.HandleFunc("GET /rooms/{id}", hub.handleRoom)
+	http.HandleFunc("GET /stats", hub.handleStats)
+	http.HandleFunc("GET /ice-servers", handleICEServers)
+	http.HandleFunc("/admin/events", hub.handleAdminEvents)
+	http.HandleFunc("POST /admin/rooms/{id}/close", hub.handleCloseRoom)
+	http.HandleFunc("/ws", hub.handleWebSocket)
+	http.HandleFunc("/ws/{roomId}", hub.handleWebSocket)
+	if enableLongPoll {
+		http.HandleFunc("POST /poll", hub.handlePollOpen)
+		http.HandleFunc("POST /poll/{clientId}", hub.handlePollSend)
+		http.HandleFunc("GET /poll/{clientId}", hub.handlePollReceive)
+	}
 
 	// Запуск сервера
-	port := ":3000"
-	log.Printf("Signaling сервер запущен на порту %s", port)
-	if err := http.ListenAndServe(port, nil); err != nil {
-		log.Fatal("Ошибка запуска сервера: ", err)
+	addr, err := listenAddr(os.Getenv("HOST"), os.Getenv("PORT"))
+	if err != nil {
+		logger.Error("Некорректный адрес для запуска сервера", "error", err)
+		os.Exit(1)
+	}
+	server := &http.Server{
+		Addr:              addr,
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       idleTimeout,
+		ConnContext:       withCountingConn,
 	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		logger.Error("Ошибка запуска сервера", "error", err)
+		os.Exit(1)
+	}
+	// Соединения оборачиваются для подсчета трафика WebSocket клиентов
+	ln = countingListener{ln}
+	server.RegisterOnShutdown(hub.closePollSessions)
+	hub.ready.Store(true)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go hub.runJanitor(ctx)
+	go hub.runRoomStateSync(ctx)
+	go hub.runWebhooks(ctx)
+
+	if redisURL != "" {
+		hub.cluster, err = newClusterRelay(ctx, redisURL)
+		if err != nil {
+			logger.Error("Ошибка подключения к Redis", "error", err)
+			os.Exit(1)
+		}
+		go hub.runCluster(ctx)
+	}
+
+	if (tlsCertFile == "") != (tlsKeyFile == "") {
+		logger.Warn("Для TLS нужны оба параметра TLS_CERT_FILE и TLS_KEY_FILE, запуск без TLS")
+	}
+
+	go func() {
+		var err error
+		if tlsCertFile != "" && tlsKeyFile != "" {
+			logger.Info("Signaling сервер запущен (HTTPS/WSS)", "addr", addr, "cert", tlsCertFile)
+			err = server.ServeTLS(ln, tlsCertFile, tlsKeyFile)
+		} else {
+			logger.Info("Signaling сервер запущен (HTTP/WS)", "addr", addr)
+			err = server.Serve(ln)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Ошибка запуска сервера", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	<-ctx.Done()
+	logger.Info("Получен сигнал остановки, завершение работы")
+	hub.shuttingDown.Store(true)
+
+	// /readyz уже отвечает 503; даем балансировщику время это заметить
+	if readinessDrainDelay > 0 {
+		logger.Info("Ожидание перед остановкой приема соединений", "delay", readinessDrainDelay.String())
+		time.Sleep(readinessDrainDelay)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// Прекращаем прием новых соединений; WebSocket соединения закрываем сами
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Ошибка остановки HTTP сервера", "error", err)
+	}
+	hub.shutdownClients(shutdownCtx)
+
+	logger.Info("Сервер остановлен")
 }
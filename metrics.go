@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Метрики Prometheus, отдаются на /metrics
+var (
+	activeRoomsGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "signaling_active_rooms",
+		Help: "Количество активных комнат",
+	})
+	connectedClientsGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "signaling_connected_clients",
+		Help: "Количество подключенных WebSocket клиентов",
+	})
+	messagesReceivedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "signaling_messages_received_total",
+		Help: "Количество полученных сообщений по типам",
+	}, []string{"type"})
+	messagesRelayedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "signaling_messages_relayed_total",
+		Help: "Количество перенаправленных signaling сообщений по типам",
+	}, []string{"type"})
+	droppedSendsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "signaling_dropped_sends_total",
+		Help: "Количество сообщений, отброшенных из-за переполнения буфера клиента",
+	})
+	deadLettersTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "signaling_dead_letters_total",
+		Help: "Количество недоставленных сообщений по типам и причинам",
+	}, []string{"type", "reason"})
+	_ = promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name:        "signaling_payload_bytes_total",
+		Help:        "Размер сообщений WebSocket клиентов до сжатия",
+		ConstLabels: prometheus.Labels{"direction": "in"},
+	}, func() float64 { return float64(serverTraffic.payloadIn.Load()) })
+	_ = promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name:        "signaling_payload_bytes_total",
+		Help:        "Размер сообщений WebSocket клиентов до сжатия",
+		ConstLabels: prometheus.Labels{"direction": "out"},
+	}, func() float64 { return float64(serverTraffic.payloadOut.Load()) })
+	_ = promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name:        "signaling_wire_bytes_total",
+		Help:        "Байты WebSocket клиентов, прошедшие через сокет",
+		ConstLabels: prometheus.Labels{"direction": "in"},
+	}, func() float64 { return float64(serverTraffic.wireIn.Load()) })
+	_ = promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name:        "signaling_wire_bytes_total",
+		Help:        "Байты WebSocket клиентов, прошедшие через сокет",
+		ConstLabels: prometheus.Labels{"direction": "out"},
+	}, func() float64 { return float64(serverTraffic.wireOut.Load()) })
+)
+
+// Известные типы сообщений; остальные сервер не принимает и учитывает как "unknown",
+// чтобы клиент не мог раздуть кардинальность метрик
+var knownMessageTypes = map[string]bool{
+	"join":          true,
+	"resume":        true,
+	"offer":         true,
+	"answer":        true,
+	"ice-candidate": true,
+	"request-offer": true,
+	"chat":          true,
+	"broadcast":     true,
+	"typing":        true,
+	"media-state":   true,
+	"kick":          true,
+	"leave":         true,
+	"rename":        true,
+	"ping":          true,
+	"whoami":        true,
+	"sync":          true,
+	"raise-hand":    true,
+	"lower-hand":    true,
+	"binary":        true,
+}
+
+// Метка типа сообщения для метрик
+func metricsMessageType(msgType string) string {
+	if knownMessageTypes[msgType] {
+		return msgType
+	}
+	return "unknown"
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Сессия long polling: клиент без WebSocket соединения.
+// POST /poll открывает сессию, POST /poll/{clientId} передает сообщение,
+// GET /poll/{clientId} забирает накопившиеся в Send сообщения.
+// ID сессии — секрет клиента и не совпадает с его ID в комнате, который видят остальные участники.
+type pollSession struct {
+	id     string
+	client *Client
+
+	// Входящие сообщения обрабатываются по одному, как в read loop WebSocket
+	recvMu sync.Mutex
+
+	mu      sync.Mutex
+	active  int         // Количество выполняющихся запросов сессии
+	timer   *time.Timer // Завершение сессии без запросов дольше pollSessionTimeout
+	endOnce sync.Once
+}
+
+// Начало запроса сессии: пока запрос выполняется, сессия не истекает
+func (s *pollSession) begin() {
+	s.mu.Lock()
+	s.active++
+	s.timer.Stop()
+	s.mu.Unlock()
+}
+
+// Завершение запроса сессии
+func (s *pollSession) finish() {
+	s.mu.Lock()
+	s.active--
+	if s.active == 0 {
+		s.timer.Reset(pollSessionTimeout)
+	}
+	s.mu.Unlock()
+}
+
+// Поиск сессии по ID
+func (h *Hub) pollSession(id string) *pollSession {
+	h.pollSessionsMu.Lock()
+	defer h.pollSessionsMu.Unlock()
+	return h.pollSessions[id]
+}
+
+// Количество открытых сессий long polling
+func (h *Hub) pollSessionCount() int {
+	h.pollSessionsMu.Lock()
+	defer h.pollSessionsMu.Unlock()
+	return len(h.pollSessions)
+}
+
+// Завершение сессии: клиент отключается так же, как при разрыве WebSocket соединения
+func (h *Hub) endPollSession(s *pollSession) {
+	s.endOnce.Do(func() {
+		h.pollSessionsMu.Lock()
+		delete(h.pollSessions, s.id)
+		h.pollSessionsMu.Unlock()
+
+		s.mu.Lock()
+		s.timer.Stop()
+		s.mu.Unlock()
+
+		h.teardown(s.client)
+		h.connLimiter.release(s.client.IP)
+		h.activeClients.Add(-1)
+		logger.Info("Сессия long polling завершена", "client_id", s.client.ID, "room_id", s.client.RoomID)
+	})
+}
+
+// Открытие сессии long polling; проверки те же, что и для WebSocket соединения
+func (h *Hub) handlePollOpen(w http.ResponseWriter, r *http.Request) {
+	if !checkOrigin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var authID, authUsername string
+	if jwtSecret != "" {
+		var err error
+		authID, authUsername, err = authenticate(r)
+		if err != nil {
+			logger.Warn("Отклонена сессия long polling с невалидным токеном", "error", err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	ip := clientIP(r)
+	if !h.connLimiter.acquire(ip) {
+		logger.Warn("Превышен лимит соединений с IP", "ip", ip)
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		return
+	}
+
+	if n := h.activeClients.Add(1); maxClients > 0 && n > int64(maxClients) {
+		h.activeClients.Add(-1)
+		h.connLimiter.release(ip)
+		logger.Warn("Достигнут лимит клиентов на сервере", "limit", maxClients)
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	client := newClient(nil)
+	client.AuthID = authID
+	client.AuthUsername = authUsername
+	client.IP = ip
+	client.UserAgent = truncatePayload(r.UserAgent(), maxUserAgentLength)
+	client.Headers = relayHeadersFrom(r)
+	client.Tenant = tenantFromRequest(r)
+	client.URLRoomID = r.URL.Query().Get("roomId")
+
+	s := &pollSession{id: newClientID(), client: client}
+	s.timer = time.AfterFunc(pollSessionTimeout, func() {
+		logger.Info("Истек таймаут сессии long polling", "client_id", client.ID)
+		h.endPollSession(s)
+	})
+
+	h.pollSessionsMu.Lock()
+	h.pollSessions[s.id] = s
+	h.pollSessionsMu.Unlock()
+
+	logger.Info("Новая сессия long polling", "client_id", client.ID, client.connAttr())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"clientId":   s.id,
+		"assignedId": client.AssignedID,
+	})
+}
+
+// Прием одного сообщения от клиента long polling
+func (h *Hub) handlePollSend(w http.ResponseWriter, r *http.Request) {
+	s := h.pollSession(r.PathValue("clientId"))
+	if s == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	s.begin()
+	defer s.finish()
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageSize))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	if s.client.ctx.Err() != nil {
+		http.Error(w, "Gone", http.StatusGone)
+		return
+	}
+
+	s.client.countPayloadIn(len(data))
+	s.recvMu.Lock()
+	h.handleMessage(s.client, websocket.TextMessage, data)
+	s.recvMu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Выдача клиенту long polling накопившихся сообщений.
+// Если сообщений нет, запрос ждет до pollWait и возвращает пустой список.
+// После закрытия клиента сервером возвращается 410 с кодом и причиной, как в close фрейме.
+func (h *Hub) handlePollReceive(w http.ResponseWriter, r *http.Request) {
+	s := h.pollSession(r.PathValue("clientId"))
+	if s == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	s.begin()
+	defer s.finish()
+
+	client := s.client
+	messages := []Message{}
+	closed := false
+
+	wait := time.NewTimer(pollWait)
+	defer wait.Stop()
+
+	select {
+	case msg := <-client.Send:
+		messages = appendPollMessage(messages, client, msg)
+	case <-client.done:
+		// Клиент закрыт: сначала отдаются сообщения, оставшиеся в буфере
+		select {
+		case msg := <-client.Send:
+			messages = appendPollMessage(messages, client, msg)
+		default:
+			closed = true
+		}
+	case msg := <-client.sendLow:
+		messages = appendPollMessage(messages, client, msg)
+	case <-wait.C:
+	case <-r.Context().Done():
+		return
+	}
+
+	// Забираем все, что уже лежит в буфере, не дожидаясь новых сообщений
+drain:
+	for !closed {
+		select {
+		case msg := <-client.Send:
+			messages = appendPollMessage(messages, client, msg)
+		default:
+			// Сообщения низкого приоритета — после основного буфера
+			select {
+			case msg := <-client.sendLow:
+				messages = appendPollMessage(messages, client, msg)
+			default:
+				break drain
+			}
+		}
+	}
+
+	// Уже полученные сообщения отдаются; о закрытии клиент узнает следующим запросом
+	if closed {
+		client.mu.Lock()
+		code, reason := client.closeCode, client.closeReason
+		client.mu.Unlock()
+		h.endPollSession(s)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusGone)
+		json.NewEncoder(w).Encode(map[string]any{
+			"code":   code,
+			"reason": reason,
+		})
+		return
+	}
+
+	data, err := json.Marshal(messages)
+	if err != nil {
+		logger.Error("Ошибка сериализации сообщений long polling", "client_id", client.ID, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+	client.countPayloadOut(len(data))
+}
+
+// Добавление сообщения в ответ long polling; бинарные фреймы через этот транспорт не передаются
+func appendPollMessage(messages []Message, client *Client, msg Message) []Message {
+	if msg.Binary != nil {
+		deadLetter(deadLetterTransport, msg, client.ID, client.RoomID)
+		return messages
+	}
+	return append(messages, msg)
+}
+
+// Закрытие сессий long polling при остановке сервера (http.Server.RegisterOnShutdown):
+// ожидающие GET запросы возвращаются сразу, и server.Shutdown не ждет pollWait
+func (h *Hub) closePollSessions() {
+	h.pollSessionsMu.Lock()
+	sessions := make([]*pollSession, 0, len(h.pollSessions))
+	for _, s := range h.pollSessions {
+		sessions = append(sessions, s)
+	}
+	h.pollSessionsMu.Unlock()
+
+	for _, s := range sessions {
+		s.client.send(Message{Type: "server-shutting-down"})
+		s.client.closeWith(websocket.CloseGoingAway, "server shutting down")
+	}
+}
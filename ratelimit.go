@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token bucket
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Пополнение и попытка взять токен; rate — токенов в секунду, burst — емкость
+func (b *tokenBucket) allow(now time.Time, rate, burst float64) bool {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Проверка лимита входящих сообщений клиента; вызывается только из read loop клиента
+func (c *Client) allowMessage(now time.Time) bool {
+	if clientMessageRate <= 0 {
+		return true
+	}
+	if c.msgBucket.last.IsZero() {
+		c.msgBucket = tokenBucket{tokens: clientMessageBurst, last: now}
+	}
+	return c.msgBucket.allow(now, clientMessageRate, clientMessageBurst)
+}
+
+// Состояние ограничений для одного IP
+type ipEntry struct {
+	bucket tokenBucket
+	active int
+}
+
+// Ограничение новых и одновременных соединений по IP
+type ipLimiter struct {
+	mu            sync.Mutex
+	perMinute     int
+	maxConcurrent int
+	entries       map[string]*ipEntry
+	lastSweep     time.Time
+}
+
+func newIPLimiter(perMinute, maxConcurrent int) *ipLimiter {
+	return &ipLimiter{
+		perMinute:     perMinute,
+		maxConcurrent: maxConcurrent,
+		entries:       make(map[string]*ipEntry),
+	}
+}
+
+// Регистрация нового соединения. При успехе вызывающий обязан вызвать release.
+func (l *ipLimiter) acquire(ip string) bool {
+	if l.perMinute <= 0 && l.maxConcurrent <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	entry, ok := l.entries[ip]
+	if !ok {
+		entry = &ipEntry{bucket: tokenBucket{tokens: float64(l.perMinute), last: now}}
+		l.entries[ip] = entry
+	}
+
+	if l.maxConcurrent > 0 && entry.active >= l.maxConcurrent {
+		return false
+	}
+	if l.perMinute > 0 && !entry.bucket.allow(now, float64(l.perMinute)/60, float64(l.perMinute)) {
+		return false
+	}
+
+	entry.active++
+	return true
+}
+
+// Освобождение соединения при отключении
+func (l *ipLimiter) release(ip string) {
+	if l.perMinute <= 0 && l.maxConcurrent <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if entry, ok := l.entries[ip]; ok && entry.active > 0 {
+		entry.active--
+	}
+}
+
+// Удаление записей IP без активных соединений, у которых бакет уже восполнился
+func (l *ipLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	for ip, entry := range l.entries {
+		if entry.active == 0 && now.Sub(entry.bucket.last) >= time.Minute {
+			delete(l.entries, ip)
+		}
+	}
+}
+
+// Ограничение создания комнат с одного IP: не больше limit за window
+type roomCreationLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRoomCreationLimiter(limit int, window time.Duration) *roomCreationLimiter {
+	return &roomCreationLimiter{
+		limit:   limit,
+		window:  window,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Попытка зарегистрировать создание комнаты с IP
+func (l *roomCreationLimiter) allow(ip string) bool {
+	if l.limit <= 0 || l.window <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) >= l.window {
+		l.lastSweep = now
+		// Бакет, не использовавшийся целое окно, уже полон и эквивалентен новому
+		for key, bucket := range l.buckets {
+			if now.Sub(bucket.last) >= l.window {
+				delete(l.buckets, key)
+			}
+		}
+	}
+
+	bucket, ok := l.buckets[ip]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(l.limit), last: now}
+		l.buckets[ip] = bucket
+	}
+	return bucket.allow(now, float64(l.limit)/l.window.Seconds(), float64(l.limit))
+}
+
+// IP клиента: из X-Forwarded-For при TRUST_PROXY, иначе из RemoteAddr
+func clientIP(r *http.Request) string {
+	if trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			if ip := strings.TrimSpace(first); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Структура для комнаты.
+// Состояние комнаты (кроме неизменяемых ID, key, CreatedAt, пароля и метаданных) читается
+// и меняется только в горутине комнаты: join, выход, пересылка и остальные операции
+// передаются ей через do и выполняются строго по одной.
+type Room struct {
+	ID      string
+	key     string             // Ключ в карте комнат: ID с префиксом арендатора
+	Clients map[string]*Client // Подключенные клиенты по ID
+	// Клиенты, потерявшие соединение и ожидающие переподключения (по ID)
+	Pending map[string]*pendingClient
+	HostID  string // Ведущий комнаты: может исключать участников
+	// Время создания комнаты
+	CreatedAt time.Time
+	relayed   atomic.Int64 // Количество доставленных через комнату сообщений
+	// С какого момента в комнате нет подключенных клиентов (ведет janitor)
+	emptySince time.Time
+	// Последняя пересылка signaling, чата и т.п. и время предупреждения о закрытии (ROOM_INACTIVITY_TIMEOUT)
+	lastActivity     time.Time
+	inactivityWarned time.Time
+	// Соленый хеш пароля, заданного создателем комнаты; nil — комната открыта
+	passwordHash []byte
+	passwordSalt []byte
+	// Метаданные от создателя комнаты (название встречи и т.п.); не меняются после создания
+	metadata json.RawMessage
+
+	cmds     chan func()   // Команды для горутины комнаты
+	stopped  chan struct{} // Закрывается после остановки горутины комнаты
+	stopping bool          // Горутина завершится после текущей команды
+	// Новые клиенты, о которых остальные еще не уведомлены (JOIN_NOTIFY_WINDOW)
+	joinBatch []*Client
+	// Поднятые руки: ID участников в порядке поднятия
+	hands []string
+	// Последний seq по парам отправитель — получатель
+	seqs map[seqKey]int64
+	// Таймеры предупреждения и закрытия по ROOM_MAX_DURATION; задаются при создании под h.roomsMu
+	durationTimers []*time.Timer
+}
+
+// Пара отправитель — получатель, для которой seq идет без пропусков
+type seqKey struct{ from, to string }
+
+// Создание комнаты и запуск ее горутины
+func newRoom(id, key string) *Room {
+	now := time.Now()
+	r := &Room{
+		ID:           id,
+		key:          key,
+		Clients:      make(map[string]*Client),
+		Pending:      make(map[string]*pendingClient),
+		CreatedAt:    now,
+		lastActivity: now,
+		cmds:         make(chan func()),
+		stopped:      make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// Следующий seq сообщения от from к to; вызывается в горутине комнаты.
+// Номер расходуется, только когда получатель уже найден, поэтому пропуск
+// означает, что сообщение не дошло (например, отброшено при переполнении буфера)
+func (r *Room) nextSeq(from, to string) int64 {
+	if r.seqs == nil {
+		r.seqs = make(map[seqKey]int64)
+	}
+	key := seqKey{from, to}
+	r.seqs[key]++
+	return r.seqs[key]
+}
+
+// Сброс счетчиков seq вышедшего участника; вызывается в горутине комнаты
+func (r *Room) forgetSeq(id string) {
+	for key := range r.seqs {
+		if key.from == id || key.to == id {
+			delete(r.seqs, key)
+		}
+	}
+}
+
+// Горутина комнаты: выполняет команды по одной, пока комнату не остановят
+func (r *Room) run() {
+	for {
+		cmd := <-r.cmds
+		cmd()
+		if r.stopping {
+			close(r.stopped)
+			return
+		}
+	}
+}
+
+// Выполнение fn в горутине комнаты с ожиданием завершения.
+// false — комната уже удалена и остановлена, fn не выполнялась.
+// fn не должна вызывать do и брать h.roomsMu: горутина комнаты заблокируется навсегда.
+func (r *Room) do(fn func()) bool {
+	done := make(chan struct{})
+	select {
+	case r.cmds <- func() { fn(); close(done) }:
+		<-done
+		return true
+	case <-r.stopped:
+		return false
+	}
+}
+
+// Остановка горутины комнаты после текущей команды; вызывается в горутине комнаты,
+// когда комната удалена из h.rooms. Последующие do вернут false.
+// Таймеры длительности останавливаются, чтобы удаленная комната не ждала в памяти до их срабатывания.
+func (r *Room) stop() {
+	r.stopping = true
+	for _, timer := range r.durationTimers {
+		timer.Stop()
+	}
+}
+
+// Отметка активности участников; вызывается в горутине комнаты
+func (r *Room) touch() {
+	r.lastActivity = time.Now()
+}
+
+// Проверка, что в комнате под этим ID находится именно данный клиент; вызывается в горутине комнаты
+func (r *Room) has(client *Client) bool {
+	return client.ID != "" && r.Clients[client.ID] == client
+}
+
+// Установка пароля комнаты; хранится только соленый SHA-256
+func (r *Room) setPassword(password string) {
+	r.passwordSalt = make([]byte, 16)
+	if _, err := rand.Read(r.passwordSalt); err != nil {
+		panic(err)
+	}
+	r.passwordHash = hashPassword(r.passwordSalt, password)
+}
+
+// Проверка пароля; для комнаты без пароля всегда true
+func (r *Room) checkPassword(password string) bool {
+	if r.passwordHash == nil {
+		return true
+	}
+	return subtle.ConstantTimeCompare(hashPassword(r.passwordSalt, password), r.passwordHash) == 1
+}
+
+// Хеш пароля с солью
+func hashPassword(salt []byte, password string) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(password))
+	return h.Sum(nil)
+}
+
+// Отключившийся клиент в пределах grace периода
+type pendingClient struct {
+	ID       string
+	Username string
+	Media    *MediaState
+	Headers  map[string]string
+	token    string // Токен для resume; действует, пока клиент ожидает переподключения
+	timer    *time.Timer
+}
+
+// Может ли client занять место ожидающего: токен переподключения или тот же ID из JWT
+func (p *pendingClient) ownedBy(client *Client, token string) bool {
+	if client.AuthID != "" && client.AuthID == p.ID {
+		return true
+	}
+	return p.token != "" && subtle.ConstantTimeCompare([]byte(p.token), []byte(token)) == 1
+}
+
+// Поиск ожидающего клиента по токену переподключения; вызывается в горутине комнаты
+func (r *Room) pendingByToken(token string) *pendingClient {
+	for _, pending := range r.Pending {
+		if pending.token != "" && subtle.ConstantTimeCompare([]byte(pending.token), []byte(token)) == 1 {
+			return pending
+		}
+	}
+	return nil
+}
+
+// Подключенный участник по токену переподключения: его старое соединение
+// еще не признано разорванным; вызывается в горутине комнаты
+func (r *Room) clientByToken(token string) *Client {
+	for _, c := range r.Clients {
+		if c.resumeToken != "" && subtle.ConstantTimeCompare([]byte(c.resumeToken), []byte(token)) == 1 {
+			return c
+		}
+	}
+	return nil
+}
+
+// Участник комнаты в списке, отправляемом новому клиенту
+type Participant struct {
+	ID       string      `json:"id"`
+	Username string      `json:"username,omitempty"`
+	Media    *MediaState `json:"media,omitempty"`
+	// Заголовки подключения из RELAY_HEADERS
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Список участников комнаты, кроме указанного клиента (nil — все); вызывается в горутине комнаты.
+// Клиенты, ожидающие переподключения, тоже включаются: для остальных они все еще в звонке.
+func roomParticipants(room *Room, except *Client) []Participant {
+	participants := make([]Participant, 0, len(room.Clients)+len(room.Pending))
+	for _, c := range room.Clients {
+		if c != except {
+			participants = append(participants, Participant{ID: c.ID, Username: c.Username, Media: c.mediaState(), Headers: c.Headers})
+		}
+	}
+	for _, pending := range room.Pending {
+		participants = append(participants, Participant{ID: pending.ID, Username: pending.Username, Media: pending.Media, Headers: pending.Headers})
+	}
+	return participants
+}
+
+// Получатель signaling сообщения msg.To; вызывается в горутине комнаты.
+// Если переслать некому, отправитель получает ошибку и возвращается nil.
+func signalingTarget(room *Room, client *Client, msg Message) *Client {
+	// Сообщение самому себе — ошибка клиента; пересылка привела бы к соединению с самим собой
+	if msg.To == client.ID {
+		logger.Warn("Signaling сообщение адресовано отправителю", "client_id", client.ID, "room_id", room.ID, "msg_type", msg.Type)
+		client.send(Message{
+			Type:   "error",
+			Code:   "invalid-target",
+			Reason: "cannot send signaling messages to yourself",
+			To:     msg.To,
+			RoomID: room.ID,
+		})
+		return nil
+	}
+
+	// Ищем получателя в комнате
+	targetClient := room.Clients[msg.To]
+	if targetClient == nil {
+		deadLetter(deadLetterPeerNotFound, msg, msg.To, room.ID)
+		client.send(Message{
+			Type:   "error",
+			Code:   "peer-not-found",
+			Reason: "no participant with this id in the room",
+			To:     msg.To,
+			RoomID: room.ID,
+		})
+		return nil
+	}
+	return targetClient
+}
+
+// Уведомление остальных участников о новом клиенте; вызывается в горутине комнаты
+func notifyUserJoined(room *Room, client *Client) {
+	notification := Message{
+		Type:     "user-joined",
+		From:     client.ID,
+		Username: client.Username,
+		RoomID:   room.ID,
+		Headers:  client.Headers,
+	}
+	for _, otherClient := range room.Clients {
+		if otherClient != client {
+			otherClient.send(notification)
+			logger.Debug("Отправлено уведомление user-joined", "client_id", otherClient.ID, "room_id", room.ID, "joined_id", client.ID)
+		}
+	}
+}
+
+// Отложенное уведомление о новом клиенте (JOIN_NOTIFY_WINDOW): входы за окно объединяются
+// в один users-joined, чтобы массовый вход не порождал O(n²) сообщений. Вызывается в горутине комнаты.
+func queueJoinNotification(room *Room, client *Client) {
+	room.joinBatch = append(room.joinBatch, client)
+	if len(room.joinBatch) == 1 {
+		// Если комнату удалят раньше, do вернет false и уведомлять будет некого
+		time.AfterFunc(joinNotifyWindow, func() {
+			room.do(func() { flushJoinNotifications(room) })
+		})
+	}
+}
+
+// Рассылка накопленного users-joined; вызывается в горутине комнаты.
+// Каждый получатель узнает только о тех, кого еще не видел: новичок из пачки уже получил
+// в joined всех, кто вошел раньше него.
+func flushJoinNotifications(room *Room) {
+	joined := make([]*Client, 0, len(room.joinBatch))
+	for _, c := range room.joinBatch {
+		// Успевшие выйти не упоминаются
+		if room.has(c) {
+			joined = append(joined, c)
+		}
+	}
+	room.joinBatch = nil
+
+	for _, recipient := range room.Clients {
+		known := slices.Index(joined, recipient)
+		var participants []Participant
+		for _, c := range joined[known+1:] {
+			participants = append(participants, Participant{ID: c.ID, Username: c.Username, Media: c.mediaState(), Headers: c.Headers})
+		}
+		if len(participants) == 0 {
+			continue
+		}
+		recipient.send(Message{
+			Type:         "users-joined",
+			RoomID:       room.ID,
+			Participants: participants,
+		})
+	}
+}
+
+// Пересылка signaling сообщения найденному получателю (msg.To — его ID). Вызывается вне горутины комнаты:
+// с RELAY_SEND_TIMEOUT отправитель ждет места в буфере медленного получателя, не задерживая комнату.
+// Поля получателя здесь не читаются: он может одновременно выходить из комнаты.
+func relaySignaling(room *Room, target *Client, msg Message) {
+	logger.Debug("Перенаправление сообщения", "client_id", msg.From, "room_id", room.ID, "msg_type", msg.Type, "to", msg.To)
+	logPayload(room.ID, msg)
+
+	sent, timedOut := target.sendTimeout(msg, relaySendTimeout, msg.To, room.ID)
+	if sent {
+		room.relayed.Add(1)
+		messagesRelayedTotal.WithLabelValues(msg.Type).Inc()
+		return
+	}
+
+	// Получатель не разбирает очередь даже с запасом времени: отключаем его,
+	// чтобы он переподключился и заново установил соединения, а не работал с пропусками
+	if timedOut && disconnectSlowPeers {
+		logger.Warn("Медленный получатель отключен", "client_id", msg.To, "room_id", room.ID, "msg_type", msg.Type, "timeout", relaySendTimeout.String())
+		target.closeWith(websocket.ClosePolicyViolation, "slow consumer")
+	}
+}
+
+// Передача роли ведущего, если ведущий покинул комнату; вызывается в горутине комнаты.
+// Ведущий, ожидающий переподключения, роль сохраняет.
+// Новым ведущим становится участник, подключившийся раньше остальных.
+func reassignHost(room *Room) {
+	if room.Clients[room.HostID] != nil || room.Pending[room.HostID] != nil {
+		return
+	}
+
+	var newHost *Client
+	for _, c := range room.Clients {
+		if newHost == nil || c.ConnectedAt.Before(newHost.ConnectedAt) {
+			newHost = c
+		}
+	}
+
+	room.HostID = ""
+	if newHost == nil {
+		return
+	}
+	room.HostID = newHost.ID
+
+	for _, c := range room.Clients {
+		c.send(Message{
+			Type:   "host-changed",
+			RoomID: room.ID,
+			HostID: room.HostID,
+		})
+	}
+	logger.Info("Назначен новый ведущий комнаты", "client_id", room.HostID, "room_id", room.ID)
+}
+
+// Причины выхода участника, передаваемые в user-left
+const (
+	leaveReasonLeave      = "leave"      // Клиент вышел сам
+	leaveReasonDisconnect = "disconnect" // Соединение потеряно
+	leaveReasonKick       = "kick"       // Исключен ведущим
+	leaveReasonReplaced   = "replaced"   // Вытеснен новым соединением с тем же ID
+	leaveReasonShutdown   = "shutdown"   // Сервер останавливается
+)
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Полное состояние комнаты для сверки списка участников; вызывается в горутине комнаты.
+// В отличие от joined, список включает и самого получателя.
+func roomState(room *Room) Message {
+	return Message{
+		Type:         "room-state",
+		RoomID:       room.ID,
+		Participants: roomParticipants(room, nil),
+		HostID:       room.HostID,
+		Hands:        roomHands(room),
+	}
+}
+
+// Обработка sync: клиент запрашивает текущее состояние своей комнаты
+func (h *Hub) handleSync(client *Client, msg Message) {
+	if client.RoomID == "" {
+		client.sendError("not-in-room", "join a room before requesting room state")
+		return
+	}
+
+	h.inClientRoom(client, msg.Type, func(room *Room) {
+		client.send(roomState(room))
+	})
+}
+
+// Периодическая рассылка room-state всем участникам комнат (ROOM_STATE_INTERVAL)
+func (h *Hub) runRoomStateSync(ctx context.Context) {
+	if roomStateInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(roomStateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, room := range h.roomList() {
+				room.do(func() {
+					if len(room.Clients) > 0 {
+						state := roomState(room)
+						for _, client := range room.Clients {
+							client.send(state)
+						}
+					}
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Статистика клиента для /stats
+type ClientStats struct {
+	ID           string    `json:"id"`
+	Username     string    `json:"username,omitempty"`
+	MessagesSent int64     `json:"messages_sent"`
+	Dropped      int64     `json:"dropped"`
+	ConnectedAt  time.Time `json:"connected_at"`
+	TrafficStats
+}
+
+// Статистика комнаты для /stats
+type RoomStats struct {
+	RoomID  string        `json:"roomId"`
+	Relayed int64         `json:"relayed"`
+	Clients []ClientStats `json:"clients"`
+	Pending int           `json:"pending"`
+}
+
+// Снимок счетчиков клиента
+func (c *Client) stats() ClientStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return ClientStats{
+		ID:           c.ID,
+		Username:     c.Username,
+		MessagesSent: c.messagesSent,
+		Dropped:      c.dropped,
+		ConnectedAt:  c.ConnectedAt,
+		TrafficStats: c.traffic.stats(),
+	}
+}
+
+// Учет входящего сообщения от клиента
+func (c *Client) countMessage() {
+	c.mu.Lock()
+	c.messagesSent++
+	c.mu.Unlock()
+}
+
+// Обработчик runtime статистики по комнатам и клиентам; доступен только с ADMIN_TOKEN
+func (h *Hub) handleStats(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	roomList := h.roomList()
+
+	result := make([]RoomStats, 0, len(roomList))
+	for _, room := range roomList {
+		// Комната, удаленная после снимка списка, в статистику не попадает
+		var stats RoomStats
+		if room.do(func() {
+			stats = RoomStats{
+				RoomID:  room.key,
+				Relayed: room.relayed.Load(),
+				Clients: make([]ClientStats, 0, len(room.Clients)),
+				Pending: len(room.Pending),
+			}
+			for _, client := range room.Clients {
+				stats.Clients = append(stats.Clients, client.stats())
+			}
+		}) {
+			result = append(result, stats)
+		}
+	}
+
+	h.clientsMu.Lock()
+	connections := len(h.clients)
+	h.clientsMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"connections":   connections,
+		"poll_sessions": h.pollSessionCount(),
+		"traffic":       serverTraffic.stats(),
+		"rooms":         result,
+	})
+}
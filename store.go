@@ -0,0 +1,66 @@
+package main
+
+// Хранилище комнат и их участников — шов для будущей кластеризации
+// (например, реализации поверх Redis для нескольких инстансов).
+// Операции с комнатами вызываются под h.roomsMu, операции с участниками — в горутине комнаты,
+// поэтому реализации не нужна собственная синхронизация для этих вызовов.
+// Состояние комнаты (Room.Clients и остальное) по-прежнему принадлежит ее горутине на этом инстансе.
+type RoomStore interface {
+	// Комната по ключу; nil — комнаты нет
+	GetRoom(key string) *Room
+	// Создание и регистрация новой комнаты; комнаты с таким ключом быть не должно
+	CreateRoom(id, key string) *Room
+	// Удаление комнаты по ключу
+	DeleteRoom(key string)
+	// Снимок списка комнат
+	Rooms() []*Room
+	// Количество комнат
+	RoomCount() int
+	// Добавление клиента в комнату под его ID
+	AddClient(room *Room, client *Client)
+	// Удаление клиента из комнаты по ID
+	RemoveClient(room *Room, clientID string)
+}
+
+// Хранилище по умолчанию: комнаты в памяти процесса
+type memoryRoomStore struct {
+	rooms map[string]*Room
+}
+
+func newMemoryRoomStore() *memoryRoomStore {
+	return &memoryRoomStore{rooms: make(map[string]*Room)}
+}
+
+func (s *memoryRoomStore) GetRoom(key string) *Room {
+	return s.rooms[key]
+}
+
+func (s *memoryRoomStore) CreateRoom(id, key string) *Room {
+	room := newRoom(id, key)
+	s.rooms[key] = room
+	return room
+}
+
+func (s *memoryRoomStore) DeleteRoom(key string) {
+	delete(s.rooms, key)
+}
+
+func (s *memoryRoomStore) Rooms() []*Room {
+	list := make([]*Room, 0, len(s.rooms))
+	for _, room := range s.rooms {
+		list = append(list, room)
+	}
+	return list
+}
+
+func (s *memoryRoomStore) RoomCount() int {
+	return len(s.rooms)
+}
+
+func (s *memoryRoomStore) AddClient(room *Room, client *Client) {
+	room.Clients[client.ID] = client
+}
+
+func (s *memoryRoomStore) RemoveClient(room *Room, clientID string) {
+	delete(room.Clients, clientID)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync/atomic"
+)
+
+// Счетчики трафика WebSocket соединений.
+// payload — размер сообщений до сжатия, wire — байты, реально прошедшие через сокет
+// (с заголовками фреймов, после permessage-deflate и, при TLS, с накладными расходами TLS).
+// Разница между ними показывает, сколько трафика экономит сжатие.
+type trafficCounters struct {
+	payloadIn  atomic.Int64
+	payloadOut atomic.Int64
+	wireIn     atomic.Int64
+	wireOut    atomic.Int64
+}
+
+// Суммарный трафик сервера по всем WebSocket соединениям
+var serverTraffic trafficCounters
+
+// Снимок счетчиков трафика для /stats
+type TrafficStats struct {
+	PayloadBytesIn  int64 `json:"payload_bytes_in"`
+	PayloadBytesOut int64 `json:"payload_bytes_out"`
+	WireBytesIn     int64 `json:"wire_bytes_in"`
+	WireBytesOut    int64 `json:"wire_bytes_out"`
+}
+
+// Снимок счетчиков
+func (t *trafficCounters) stats() TrafficStats {
+	return TrafficStats{
+		PayloadBytesIn:  t.payloadIn.Load(),
+		PayloadBytesOut: t.payloadOut.Load(),
+		WireBytesIn:     t.wireIn.Load(),
+		WireBytesOut:    t.wireOut.Load(),
+	}
+}
+
+// Учет полученного от клиента сообщения
+func (c *Client) countPayloadIn(n int) {
+	c.traffic.payloadIn.Add(int64(n))
+	serverTraffic.payloadIn.Add(int64(n))
+}
+
+// Учет отправленного клиенту сообщения
+func (c *Client) countPayloadOut(n int) {
+	c.traffic.payloadOut.Add(int64(n))
+	serverTraffic.payloadOut.Add(int64(n))
+}
+
+// TCP соединение со счетчиком байт. Считает только после upgrade до WebSocket,
+// чтобы HTTP запросы к /health, /metrics и т.п. не попадали в статистику.
+type countingConn struct {
+	net.Conn
+	traffic *trafficCounters // Счетчики клиента; nil — соединение еще не стало WebSocket
+	enabled atomic.Bool
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 && c.enabled.Load() {
+		c.traffic.wireIn.Add(int64(n))
+		serverTraffic.wireIn.Add(int64(n))
+	}
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 && c.enabled.Load() {
+		c.traffic.wireOut.Add(int64(n))
+		serverTraffic.wireOut.Add(int64(n))
+	}
+	return n, err
+}
+
+// Включение подсчета байт соединения в счетчики клиента; вызывается один раз после upgrade
+func (c *countingConn) track(traffic *trafficCounters) {
+	c.traffic = traffic
+	c.enabled.Store(true)
+}
+
+// Listener, оборачивающий принятые соединения в countingConn
+type countingListener struct {
+	net.Listener
+}
+
+func (l countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: conn}, nil
+}
+
+type countingConnKey struct{}
+
+// Сохранение countingConn в контексте запроса (http.Server.ConnContext)
+func withCountingConn(ctx context.Context, conn net.Conn) context.Context {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if cc, ok := conn.(*countingConn); ok {
+		return context.WithValue(ctx, countingConnKey{}, cc)
+	}
+	return ctx
+}
+
+// countingConn соединения, по которому пришел запрос; nil, если сервер запущен без countingListener
+func countingConnFrom(ctx context.Context) *countingConn {
+	cc, _ := ctx.Value(countingConnKey{}).(*countingConn)
+	return cc
+}
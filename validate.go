@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Проверка структуры payload signaling сообщений (включается STRICT_VALIDATION).
+// offer/answer должны содержать RTCSessionDescription (sdp и type),
+// ice-candidate — RTCIceCandidate с полем candidate.
+func validateSignalingPayload(msgType string, data json.RawMessage) error {
+	switch msgType {
+	case "offer", "answer":
+		var payload struct {
+			SDP  *string `json:"sdp"`
+			Type *string `json:"type"`
+		}
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return errors.New("data must be a JSON object")
+		}
+		if payload.SDP == nil || *payload.SDP == "" {
+			return errors.New("data.sdp must be a non-empty string")
+		}
+		if payload.Type == nil || *payload.Type == "" {
+			return errors.New("data.type must be a non-empty string")
+		}
+	case "ice-candidate":
+		var payload struct {
+			Candidate *string `json:"candidate"`
+		}
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return errors.New("data must be a JSON object")
+		}
+		if payload.Candidate == nil {
+			return errors.New("data.candidate must be a string")
+		}
+	}
+	return nil
+}
+
+// Очистка имени пользователя: управляющие символы удаляются, пробелы по краям обрезаются.
+// Имя длиннее maxUsernameLength символов отклоняется.
+func sanitizeUsername(name string) (string, error) {
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name))
+
+	if maxUsernameLength > 0 && utf8.RuneCountInString(name) > maxUsernameLength {
+		return "", fmt.Errorf("username must be at most %d characters", maxUsernameLength)
+	}
+	return name, nil
+}
+
+// Проверка типа сообщения по ALLOWED_MESSAGE_TYPES; неизвестные серверу типы не принимаются никогда
+func messageTypeAllowed(msgType string) bool {
+	if !knownMessageTypes[msgType] {
+		return false
+	}
+	return len(allowedMessageTypes) == 0 || slices.Contains(allowedMessageTypes, msgType)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Размер очереди webhook событий; при переполнении события отбрасываются
+const webhookQueueSize = 256
+
+// Событие жизненного цикла комнаты для WEBHOOK_URL
+type WebhookEvent struct {
+	Event            string    `json:"event"` // room-created или room-destroyed
+	RoomID           string    `json:"roomId"`
+	ParticipantCount int       `json:"participantCount"`
+	Timestamp        time.Time `json:"timestamp"`
+}
+
+// Постановка события в очередь отправки; никогда не блокирует вызывающего
+func (h *Hub) queueWebhook(event, roomKey string, participants int) {
+	if webhookURL == "" {
+		return
+	}
+
+	select {
+	case h.webhooks <- WebhookEvent{Event: event, RoomID: roomKey, ParticipantCount: participants, Timestamp: time.Now()}:
+	default:
+		logger.Warn("Очередь webhook переполнена, событие отброшено", "event", event, "room_id", roomKey)
+	}
+}
+
+// Фоновая отправка webhook событий по одному, в порядке возникновения
+func (h *Hub) runWebhooks(ctx context.Context) {
+	if webhookURL == "" {
+		return
+	}
+
+	client := &http.Client{Timeout: webhookTimeout}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-h.webhooks:
+			deliverWebhook(ctx, client, event)
+		}
+	}
+}
+
+// Отправка события с ограниченным числом повторов и экспоненциальной паузой между ними
+func deliverWebhook(ctx context.Context, client *http.Client, event WebhookEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		logger.Error("Ошибка сериализации webhook события", "event", event.Event, "error", err)
+		return
+	}
+
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err := postWebhook(ctx, client, body)
+		if err == nil {
+			logger.Debug("Webhook отправлен", "event", event.Event, "room_id", event.RoomID, "attempt", attempt)
+			return
+		}
+		if attempt > webhookRetries {
+			logger.Warn("Webhook не доставлен", "event", event.Event, "room_id", event.RoomID, "attempts", attempt, "error", err)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// Один POST на WEBHOOK_URL; ответ вне диапазона 2xx считается ошибкой
+func postWebhook(ctx context.Context, client *http.Client, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if webhookAuthHeader != "" {
+		req.Header.Set("Authorization", webhookAuthHeader)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("статус ответа %d", resp.StatusCode)
+	}
+	return nil
+}
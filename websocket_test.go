@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Сколько тест ждет очередного сообщения или условия от сервера
+const wsWait = 2 * time.Second
+
+// Тестовый клиент поверх настоящего WebSocket соединения с httptest сервером
+type wsPeer struct {
+	conn *websocket.Conn
+}
+
+// Сервер с handleWebSocket; закрывается по окончании теста. Close не ждет обработчиков
+// перехваченных соединений, поэтому их ждем через clientsWG: иначе они застали бы
+// настройки, которые меняет следующий тест
+func startWSServer(t testing.TB, h *Hub) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(h.handleWebSocket))
+	t.Cleanup(func() {
+		srv.Close()
+		h.clientsWG.Wait()
+	})
+	return srv
+}
+
+// Подключение тестового клиента; соединение закрывается по окончании теста
+func dialPeer(t testing.TB, srv *httptest.Server) *wsPeer {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial %s: %v", url, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &wsPeer{conn: conn}
+}
+
+// Сообщение от имени клиента
+func (p *wsPeer) send(msg Message) error {
+	p.conn.SetWriteDeadline(time.Now().Add(wsWait))
+	if err := p.conn.WriteJSON(msg); err != nil {
+		return fmt.Errorf("send %s: %w", msg.Type, err)
+	}
+	return nil
+}
+
+// Ожидание сообщения типа msgType; сообщения других типов пропускаются
+func (p *wsPeer) recv(msgType string) (Message, error) {
+	p.conn.SetReadDeadline(time.Now().Add(wsWait))
+	for {
+		var msg Message
+		if err := p.conn.ReadJSON(&msg); err != nil {
+			return Message{}, fmt.Errorf("no %q message: %w", msgType, err)
+		}
+		if msg.Type == msgType {
+			return msg, nil
+		}
+	}
+}
+
+// send с остановкой теста при ошибке
+func (p *wsPeer) write(t testing.TB, msg Message) {
+	t.Helper()
+	if err := p.send(msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// recv с остановкой теста при ошибке
+func (p *wsPeer) expect(t testing.TB, msgType string) Message {
+	t.Helper()
+	msg, err := p.recv(msgType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// Подключение и вход в комнату с ожиданием joined
+func joinPeer(t testing.TB, srv *httptest.Server, roomID, from string) (*wsPeer, Message) {
+	t.Helper()
+	peer := dialPeer(t, srv)
+	peer.write(t, Message{Type: "join", RoomID: roomID, From: from})
+	return peer, peer.expect(t, "joined")
+}
+
+// Есть ли комната в hub
+func hasRoom(h *Hub, roomID string) bool {
+	return h.room(roomKey("", roomID)) != nil
+}
+
+// Ожидание условия, которое сервер выполняет асинхронно
+func waitUntil(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(wsWait)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: not reached within %s", what, wsWait)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Без grace периода разрыв соединения сразу удаляет клиента из комнаты
+func noReconnectGrace(t *testing.T) {
+	saved := reconnectGrace
+	reconnectGrace = 0
+	t.Cleanup(func() { reconnectGrace = saved })
+}
+
+func TestWebSocketJoinNotifiesParticipants(t *testing.T) {
+	srv := startWSServer(t, newHub())
+
+	alice, joined := joinPeer(t, srv, "join", "alice")
+	if joined.HostID != "alice" || len(joined.Participants) != 0 {
+		t.Fatalf("alice joined = %+v, want host alice and no participants", joined)
+	}
+
+	_, joined = joinPeer(t, srv, "join", "bob")
+	if joined.HostID != "alice" || len(joined.Participants) != 1 || joined.Participants[0].ID != "alice" {
+		t.Fatalf("bob joined = %+v, want host alice and participant alice", joined)
+	}
+
+	if msg := alice.expect(t, "user-joined"); msg.From != "bob" {
+		t.Fatalf("user-joined from %q, want bob", msg.From)
+	}
+}
+
+func TestWebSocketSignalingRelay(t *testing.T) {
+	tests := []struct {
+		msgType string
+		data    string
+	}{
+		{"offer", `{"type":"offer","sdp":"v=0"}`},
+		{"answer", `{"type":"answer","sdp":"v=0"}`},
+		{"ice-candidate", `{"candidate":"candidate:1 1 udp 1 127.0.0.1 9 typ host","sdpMid":"0"}`},
+	}
+
+	srv := startWSServer(t, newHub())
+	alice, _ := joinPeer(t, srv, "relay", "alice")
+	bob, _ := joinPeer(t, srv, "relay", "bob")
+
+	for _, tt := range tests {
+		t.Run(tt.msgType, func(t *testing.T) {
+			alice.write(t, Message{Type: tt.msgType, To: "bob", Data: []byte(tt.data)})
+
+			msg := bob.expect(t, tt.msgType)
+			if msg.From != "alice" || string(msg.Data) != tt.data {
+				t.Fatalf("bob got %s from %q with data %s, want from alice with %s", msg.Type, msg.From, msg.Data, tt.data)
+			}
+		})
+	}
+
+	t.Run("unknown peer", func(t *testing.T) {
+		alice.write(t, Message{Type: "offer", To: "carol", Data: []byte(`{"sdp":"v=0"}`)})
+		if msg := alice.expect(t, "error"); msg.Code != "peer-not-found" {
+			t.Fatalf("error code %q, want peer-not-found", msg.Code)
+		}
+	})
+}
+
+func TestWebSocketLeaveCleansUpRoom(t *testing.T) {
+	noReconnectGrace(t)
+
+	tests := []struct {
+		name   string
+		leave  func(t *testing.T, peer *wsPeer)
+		reason string
+	}{
+		{
+			name:   "leave message",
+			leave:  func(t *testing.T, peer *wsPeer) { peer.write(t, Message{Type: "leave"}) },
+			reason: leaveReasonLeave,
+		},
+		{
+			name:   "disconnect",
+			leave:  func(t *testing.T, peer *wsPeer) { peer.conn.Close() },
+			reason: leaveReasonDisconnect,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHub()
+			srv := startWSServer(t, h)
+			alice, _ := joinPeer(t, srv, "cleanup", "alice")
+			bob, _ := joinPeer(t, srv, "cleanup", "bob")
+
+			tt.leave(t, bob)
+			msg := alice.expect(t, "user-left")
+			if msg.From != "bob" || msg.Reason != tt.reason {
+				t.Fatalf("user-left = %+v, want from bob with reason %s", msg, tt.reason)
+			}
+			if !hasRoom(h, "cleanup") {
+				t.Fatal("room deleted while alice is still in it")
+			}
+
+			tt.leave(t, alice)
+			waitUntil(t, "room deleted once empty", func() bool { return !hasRoom(h, "cleanup") })
+		})
+	}
+}
+
+// Параллельные join, signaling, leave и разрывы в одной комнате; рассчитан на запуск с -race
+func TestWebSocketConcurrentJoinLeave(t *testing.T) {
+	noReconnectGrace(t)
+	h := newHub()
+	srv := startWSServer(t, h)
+
+	const clients = 32
+	errs := make(chan error, clients)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- func() error {
+				url := "ws" + strings.TrimPrefix(srv.URL, "http")
+				conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+				if err != nil {
+					return err
+				}
+				defer conn.Close()
+				peer := &wsPeer{conn: conn}
+
+				id := fmt.Sprintf("client-%d", i)
+				if err := peer.send(Message{Type: "join", RoomID: "busy", From: id}); err != nil {
+					return err
+				}
+				if _, err := peer.recv("joined"); err != nil {
+					return fmt.Errorf("%s: %w", id, err)
+				}
+				// Получатель мог уже выйти; peer-not-found допустим
+				if err := peer.send(Message{Type: "offer", To: fmt.Sprintf("client-%d", (i+1)%clients), Data: []byte(`{"sdp":"v=0"}`)}); err != nil {
+					return err
+				}
+				// Половина выходит сообщением leave, остальные просто рвут соединение
+				if i%2 == 0 {
+					return peer.send(Message{Type: "leave"})
+				}
+				return nil
+			}()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitUntil(t, "room deleted once everyone left", func() bool { return !hasRoom(h, "busy") })
+}